@@ -1,3 +1,5 @@
 module json-extract
 
-go 1.22.2
+go 1.23
+
+require golang.org/x/text v0.22.0
@@ -0,0 +1,41 @@
+package jsonextract
+
+// Arena is a growable buffer that an Extractor copies results into, see
+// Extractor.Arena. Reusing one across extractions, for instance from a
+// sync.Pool, makes copying results cost no allocations once the buffer has
+// grown to fit them:
+//
+//	arena := pool.Get().(*jsonextract.Arena)
+//	arena.Reset()
+//	e.ZeroCopy, e.Arena = true, arena
+//	... use e.ResultsBytes ...
+//	pool.Put(arena)
+//
+// An Arena must not be shared by extractions running at the same time.
+type Arena struct {
+	buf []byte
+}
+
+// NewArena returns an Arena with room for size bytes of results.
+func NewArena(size int) *Arena {
+	return &Arena{buf: make([]byte, 0, size)}
+}
+
+// Reset empties the arena for reuse. Results copied into it before are
+// overwritten by later ones.
+func (a *Arena) Reset() {
+	a.buf = a.buf[:0]
+}
+
+// Len returns the number of bytes copied in since the last Reset.
+func (a *Arena) Len() int {
+	return len(a.buf)
+}
+
+// copy appends b and returns the copy, capped so appending to it cannot
+// overwrite the next result.
+func (a *Arena) copy(b []byte) []byte {
+	start := len(a.buf)
+	a.buf = append(a.buf, b...)
+	return a.buf[start:len(a.buf):len(a.buf)]
+}
@@ -0,0 +1,82 @@
+package jsonextract
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	arena := NewArena(8)
+	extractInto := func(doc string) map[string][][]byte {
+		raw := []byte(doc)
+		e := NewExtractor(raw, CompilePaths(map[string]string{"a": "a[*]", "b": "b"}))
+		e.ZeroCopy, e.Arena = true, arena
+		if err := e.Extract(); err != nil {
+			t.Fatal(err)
+		}
+		for i := range raw {
+			raw[i] = 'x' // results must not point into the document
+		}
+		return e.ResultsBytes
+	}
+
+	got := extractInto(`{"a":[1,"two",[3]],"b":{"c":4}}`)
+	want := map[string][][]byte{"a": {[]byte("1"), []byte("two"), []byte("[3]")}, "b": {[]byte(`{"c":4}`)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results %q, want %q", got, want)
+	}
+	if n := arena.Len(); n != len("1two[3]{\"c\":4}") {
+		t.Errorf("arena holds %d bytes", n)
+	}
+
+	// appending to one result must leave the next alone
+	_ = append(got["a"][0], "!!!"...)
+	if string(got["a"][1]) != "two" {
+		t.Errorf("appending to a result overwrote the next: %q", got["a"][1])
+	}
+
+	arena.Reset()
+	if arena.Len() != 0 {
+		t.Errorf("arena holds %d bytes after Reset", arena.Len())
+	}
+	got = extractInto(`{"a":["x\"y"],"b":null}`)
+	want = map[string][][]byte{"a": {[]byte(`x\"y`)}, "b": {[]byte("null")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results after Reset %q, want %q", got, want)
+	}
+}
+
+func TestArenaPool(t *testing.T) {
+	pool := sync.Pool{New: func() any { return NewArena(0) }}
+	docs := []string{`{"id":1,"name":"ann"}`, `{"id":22,"name":"bob"}`, `{"id":333,"name":"cy"}`}
+	for round := range 3 {
+		for _, doc := range docs {
+			arena := pool.Get().(*Arena)
+			arena.Reset()
+			e := NewExtractor([]byte(doc), CompilePaths(map[string]string{"id": "id", "name": "name"}))
+			e.ZeroCopy, e.Arena = true, arena
+			if err := e.Extract(); err != nil {
+				t.Fatal(err)
+			}
+			wantResults, err := extract(doc, map[string]string{"id": "id", "name": "name"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, values := range wantResults.Results {
+				if got := e.ResultsBytes[name]; len(got) != 1 || string(got[0]) != values[0] {
+					t.Errorf("round %d, %s: %s %q, want %q", round, doc, name, got, values)
+				}
+			}
+			pool.Put(arena)
+		}
+	}
+}
+
+func BenchmarkExtractArena(b *testing.B) {
+	arena := NewArena(0)
+	benchmarkExtract(b, func(e *Extractor) {
+		arena.Reset()
+		e.ZeroCopy, e.Arena = true, arena
+	})
+}
@@ -0,0 +1,94 @@
+package jsonextract
+
+import (
+	"strconv"
+	"unicode"
+)
+
+// PathBuilder writes a query one step at a time, quoting keys wherever the
+// query syntax would otherwise read them differently, as for a key holding a
+// dot or a bracket. Builders are values, so one can be extended in several
+// ways:
+//
+//	users := Path("users")
+//	emails := users.Wildcard().Key("email")      // users[*].email
+//	admins := users.Filter("role", "=", "admin") // users[?role="admin"]
+type PathBuilder struct {
+	query string
+}
+
+// Path starts a query at the root, followed by keys.
+func Path(keys ...string) PathBuilder {
+	var b PathBuilder
+	for _, key := range keys {
+		b = b.Key(key)
+	}
+	return b
+}
+
+// Key selects the member named key.
+func (b PathBuilder) Key(key string) PathBuilder {
+	return b.step(queryKey(key))
+}
+
+// AnyKey selects every member, like '*'.
+func (b PathBuilder) AnyKey() PathBuilder {
+	return b.step("*")
+}
+
+// Index selects element i of an array; negative indices count from the end.
+func (b PathBuilder) Index(i int) PathBuilder {
+	return PathBuilder{b.query + "[" + strconv.Itoa(i) + "]"}
+}
+
+// Wildcard selects every element of an array, like [*].
+func (b PathBuilder) Wildcard() PathBuilder {
+	return PathBuilder{b.query + "[*]"}
+}
+
+// Filter selects the elements of an array whose member key compares to
+// value with op, one of = != < <= > >=, like [?key op "value"]. An empty key
+// compares the element itself. value is always a literal, never a @field
+// reference or a JSON pattern, and compares as in any filter.
+func (b PathBuilder) Filter(key, op, value string) PathBuilder {
+	if key != "" {
+		key = queryKey(key)
+	}
+	return PathBuilder{b.query + "[?" + key + op + strconv.Quote(value) + "]"}
+}
+
+func (b PathBuilder) step(segment string) PathBuilder {
+	if b.query == "" {
+		return PathBuilder{b.query + segment}
+	}
+	return PathBuilder{b.query + "." + segment}
+}
+
+// String returns the query, or "$" for the root itself.
+func (b PathBuilder) String() string {
+	if b.query == "" {
+		return "$"
+	}
+	return b.query
+}
+
+// Compile compiles the query for the result name, as CompilePathsStrict does.
+func (b PathBuilder) Compile(name string) (*PathNode, error) {
+	return CompilePathsStrict(map[string]string{name: b.String()})
+}
+
+// queryKey returns key as a query segment: as it is when it consists of
+// letters, digits, '_' and '-' only, and quoted otherwise.
+func queryKey(key string) string {
+	plain := key != ""
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return key
+	}
+	return strconv.Quote(key)
+}
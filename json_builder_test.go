@@ -0,0 +1,63 @@
+package jsonextract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPathBuilder(t *testing.T) {
+	tests := []struct {
+		builder PathBuilder
+		query   string
+	}{
+		{Path("orders").Index(0).Key("total"), "orders[0].total"},
+		{Path().Key("users").Wildcard().Key("email"), "users[*].email"},
+		{Path("users", "email"), "users.email"},
+		{Path("m").Index(1).Index(-1), "m[1][-1]"},
+		{Path("a").AnyKey().Key("b"), "a.*.b"},
+		{Path("items").Filter("price", ">", "100").Key("id"), `items[?price>"100"].id`},
+		{Path("tags").Filter("", "!=", "urgent"), `tags[?!="urgent"]`},
+		{Path("my-key_1", "é"), "my-key_1.é"},
+		{Path(), "$"},
+	}
+	for _, test := range tests {
+		if got := test.builder.String(); got != test.query {
+			t.Errorf("built %s, want %s", got, test.query)
+		}
+		built, err := test.builder.Compile("v")
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if want := CompilePaths(map[string]string{"v": test.query}); !reflect.DeepEqual(built, want) {
+			t.Errorf("%s: built tree differs from the compiled query", test.query)
+		}
+	}
+}
+
+func TestPathBuilderQuoting(t *testing.T) {
+	doc := `{"a.b":{"c[0]":1,"d\"e":2,"":3,"*":4,"x y":5},"list":[{"k.v":"p]q","n":6}],"z":0}`
+	tests := []struct {
+		builder PathBuilder
+		want    []string
+	}{
+		{Path("a.b", "c[0]"), []string{"1"}},
+		{Path("a.b", `d"e`), []string{"2"}},
+		{Path("a.b", ""), []string{"3"}},
+		{Path("a.b", "*"), []string{"4"}}, // the key, not every member
+		{Path("a.b", "x y"), []string{"5"}},
+		{Path("list").Filter("k.v", "=", "p]q").Key("n"), []string{"6"}},
+	}
+	for _, test := range tests {
+		if _, err := test.builder.Compile("v"); err != nil {
+			t.Errorf("%s: %v", test.builder, err)
+			continue
+		}
+		e, err := extract(doc, map[string]string{"v": test.builder.String(), "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.builder, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want, "z": {"0"}})
+	}
+}
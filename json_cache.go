@@ -0,0 +1,73 @@
+package jsonextract
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PathCacheSize is the number of compiled trees CompilePathsCached keeps.
+// Beyond it the least recently used tree is dropped, so caching queries
+// supplied by users cannot grow memory without bound.
+var PathCacheSize = 256
+
+type cachedTree struct {
+	key  string
+	root *PathNode
+}
+
+var compiledPaths = struct {
+	sync.Mutex
+	trees map[string]*list.Element // elements of order
+	order list.List                // of *cachedTree, most recently used first
+}{trees: make(map[string]*list.Element)}
+
+// CompilePathsCached returns a shared compiled tree for paths. Trees are never
+// modified by extraction, so the same tree can be used by many extractors.
+// See PathCacheSize for how many are kept.
+func CompilePathsCached(paths map[string]string) *PathNode {
+	key := pathsCacheKey(paths)
+
+	compiledPaths.Lock()
+	defer compiledPaths.Unlock()
+
+	if elem, ok := compiledPaths.trees[key]; ok {
+		compiledPaths.order.MoveToFront(elem)
+		return elem.Value.(*cachedTree).root
+	}
+	root := CompilePaths(paths)
+	compiledPaths.trees[key] = compiledPaths.order.PushFront(&cachedTree{key, root})
+	for compiledPaths.order.Len() > max(PathCacheSize, 0) {
+		oldest := compiledPaths.order.Back()
+		compiledPaths.order.Remove(oldest)
+		delete(compiledPaths.trees, oldest.Value.(*cachedTree).key)
+	}
+	return root
+}
+
+// ResetPathCache drops every tree cached by CompilePathsCached.
+func ResetPathCache() {
+	compiledPaths.Lock()
+	defer compiledPaths.Unlock()
+	clear(compiledPaths.trees)
+	compiledPaths.order.Init()
+}
+
+func pathsCacheKey(paths map[string]string) string {
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(strconv.Quote(name))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(paths[name]))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
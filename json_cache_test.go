@@ -0,0 +1,71 @@
+package jsonextract
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCompilePathsCached(t *testing.T) {
+	ResetPathCache()
+	a := CompilePathsCached(map[string]string{"id": "users[*].id", "n": "name"})
+	b := CompilePathsCached(map[string]string{"n": "name", "id": "users[*].id"})
+	if a != b {
+		t.Error("the same paths compiled twice")
+	}
+	if c := CompilePathsCached(map[string]string{"id": "users[*].id", "n": "title"}); c == a {
+		t.Error("a different query shares the tree")
+	}
+	if c := CompilePathsCached(map[string]string{"id": "users[*].id", "m": "name"}); c == a {
+		t.Error("a different name shares the tree")
+	}
+	// "a=b" plus "c" must not collide with "a" plus "b=c"
+	x := CompilePathsCached(map[string]string{"a=b": "c"})
+	y := CompilePathsCached(map[string]string{"a": "b=c"})
+	if x == y {
+		t.Error("ambiguous keys share a tree")
+	}
+}
+
+func TestCompilePathsCachedConcurrent(t *testing.T) {
+	ResetPathCache()
+	paths := map[string]string{"a": "a.b[*]"}
+	roots := make([]*PathNode, 8)
+	var wg sync.WaitGroup
+	for i := range roots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roots[i] = CompilePathsCached(paths)
+		}()
+	}
+	wg.Wait()
+	for _, root := range roots {
+		if root != roots[0] {
+			t.Fatal("concurrent calls compiled separate trees")
+		}
+	}
+}
+
+func TestPathCacheSize(t *testing.T) {
+	defer func(size int) { PathCacheSize = size }(PathCacheSize)
+	PathCacheSize = 2
+	ResetPathCache()
+	query := func(i int) map[string]string { return map[string]string{"v": fmt.Sprintf("k%d", i)} }
+
+	first := CompilePathsCached(query(1))
+	CompilePathsCached(query(2))
+	CompilePathsCached(query(1)) // now the most recently used
+	CompilePathsCached(query(3)) // drops 2
+	if CompilePathsCached(query(1)) != first {
+		t.Error("the recently used tree was dropped")
+	}
+	if n := compiledPaths.order.Len(); n != 2 {
+		t.Errorf("%d trees cached, want 2", n)
+	}
+
+	ResetPathCache()
+	if CompilePathsCached(query(1)) == first {
+		t.Error("ResetPathCache kept a tree")
+	}
+}
@@ -0,0 +1,37 @@
+package jsonextract
+
+import "fmt"
+
+// Diagnostics explains for every result name that matched nothing why not:
+// "items[5] out of range, the array has 3 elements" when an array on the
+// path was present but too short for its index, and "not found" when some
+// key or array on the path was absent. Names that matched are left out.
+func (e *Extractor) Diagnostics() map[string]string {
+	diagnostics := make(map[string]string)
+	e.diagnose(e.Root, e.ResultWatcher, "", "", diagnostics)
+	return diagnostics
+}
+
+// diagnose walks node and its watcher, carrying the query so far and the
+// first short array above.
+func (e *Extractor) diagnose(node *PathNode, watcher *PathResultWatcher, query, short string, diagnostics map[string]string) {
+	if node != e.Root {
+		if query != "" && !node.Nested && !node.Recursive {
+			query += "."
+		}
+		query += node.Segment
+	}
+	if watcher.Short && short == "" {
+		short = fmt.Sprintf("%s out of range, the array has %d elements", query, watcher.Length)
+	}
+	if node.IsTerminal && !e.matched[node.Name] {
+		if short != "" {
+			diagnostics[node.Name] = short
+		} else {
+			diagnostics[node.Name] = "not found"
+		}
+	}
+	for _, child := range node.Children {
+		e.diagnose(child, watcher.Children[child], query, short, diagnostics)
+	}
+}
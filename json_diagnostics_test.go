@@ -0,0 +1,34 @@
+package jsonextract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	doc := `{"items":[1,2,3],"objs":[{"a":1}],"m":[[1],[2,3]],"z":0}`
+	e, err := extract(doc, map[string]string{
+		"ok":     "items[1]",
+		"short":  "items[5]",
+		"absent": "nope[0]",
+		"deep":   "objs[3].a",
+		"key":    "objs[0].b",
+		"nested": "m[0][1]",
+		"outer":  "m[5][0]",
+		"z":      "z",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"short":  "items[5] out of range, the array has 3 elements",
+		"absent": "not found",
+		"deep":   "objs[3] out of range, the array has 1 elements",
+		"key":    "not found",
+		"nested": "m[0][1] out of range, the array has 1 elements",
+		"outer":  "m[5] out of range, the array has 2 elements",
+	}
+	if got := e.Diagnostics(); !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics %v, want %v", got, want)
+	}
+}
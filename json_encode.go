@@ -0,0 +1,102 @@
+package jsonextract
+
+import (
+	"errors"
+	"slices"
+	"unicode/utf8"
+)
+
+// ResultsJSON encodes the results as one JSON object holding the array of
+// values of every path, in name order, e.g. {"id":[7],"name":["ann"]}. It
+// needs RecordTypes: strings are quoted, whether or not UnescapeStrings or
+// Verbatim was set, and numbers, booleans, null, objects and arrays are
+// written as they are. A value JSON does not allow, like Lenient's NaN or a
+// transform's output, is quoted instead. Results stored without a type,
+// those of #array and #group paths and Defaults, are written as they are
+// when they are valid JSON and quoted otherwise.
+func (e *Extractor) ResultsJSON() ([]byte, error) {
+	if !e.RecordTypes {
+		return nil, errors.New("ResultsJSON needs RecordTypes")
+	}
+	var names []string
+	if e.ZeroCopy {
+		for name := range e.ResultsBytes {
+			names = append(names, name)
+		}
+	} else {
+		for name := range e.Results {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	buf := []byte{'{'}
+	for i, name := range names {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendQuoted(buf, []byte(name))
+		buf = append(buf, ':', '[')
+		n := len(e.Results[name])
+		if e.ZeroCopy {
+			n = len(e.ResultsBytes[name])
+		}
+		for j := 0; j < n; j++ {
+			if j > 0 {
+				buf = append(buf, ',')
+			}
+			var value []byte
+			if e.ZeroCopy {
+				value = e.ResultsBytes[name][j]
+			} else {
+				value = []byte(e.Results[name][j])
+			}
+			if types := e.Types[name]; j < len(types) && types[j] == String {
+				buf = appendQuoted(buf, e.stringText(value))
+			} else if Validate(value) == nil {
+				buf = append(buf, value...)
+			} else {
+				buf = appendQuoted(buf, value)
+			}
+		}
+		buf = append(buf, ']')
+	}
+	return append(buf, '}'), nil
+}
+
+// stringText returns the text of a stored string result, undoing the
+// escapes or quotes that UnescapeStrings and Verbatim leave.
+func (e *Extractor) stringText(value []byte) []byte {
+	if e.UnescapeStrings {
+		return value
+	}
+	if e.Verbatim && len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return unescaped(value)
+}
+
+// appendQuoted appends s as a JSON string. Invalid UTF-8 becomes U+FFFD.
+func appendQuoted(buf, s []byte) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\n':
+			buf = append(buf, '\\', 'n')
+		case r == '\r':
+			buf = append(buf, '\\', 'r')
+		case r == '\t':
+			buf = append(buf, '\\', 't')
+		case r < 0x20:
+			buf = append(buf, '\\', 'u', '0', '0', hex[r>>4], hex[r&0xf])
+		default:
+			buf = utf8.AppendRune(buf, r)
+		}
+		s = s[size:]
+	}
+	return append(buf, '"')
+}
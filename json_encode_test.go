@@ -0,0 +1,73 @@
+package jsonextract
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResultsJSON(t *testing.T) {
+	doc := `{"s":"a\"bé\n","q":"123","n":-1.5e3,"t":true,"f":false,"z":null,"o":{"k": [1]},"l":[1,"x"]}`
+	paths := map[string]string{
+		"s": "s", "q": "q", "n": "n", "t": "t", "f": "f", "z": "z", "o": "o",
+		"l": "l[*]", "all": "l[*]#array", "none": "missing",
+	}
+	want := `{"all":[[1,"x"]],"f":[false],"l":[1,"x"],"n":[-1.5e3],"none":["-"],` +
+		`"o":[{"k": [1]}],"q":["123"],"s":["a\"bé\n"],"t":[true],"z":[null]}`
+	setups := map[string]func(*Extractor){
+		"plain":     nil,
+		"unescaped": func(e *Extractor) { e.UnescapeStrings = true },
+		"verbatim":  func(e *Extractor) { e.Verbatim = true },
+		"zero copy": func(e *Extractor) { e.ZeroCopy = true },
+	}
+	for name, setup := range setups {
+		e, err := extract(doc, paths, func(e *Extractor) {
+			e.RecordTypes = true
+			e.Defaults = map[string]string{"none": "-"} // not JSON, so quoted
+			if setup != nil {
+				setup(e)
+			}
+		})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := e.ResultsJSON()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: encoded\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestResultsJSONTypes(t *testing.T) {
+	e, err := extract(`{"v":[7,"7",true,null,{"a":1},[2]]}`, map[string]string{"v": "v[*]"}, func(e *Extractor) {
+		e.RecordTypes = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := e.ResultsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string][]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("%s: %v", encoded, err)
+	}
+	want := []interface{}{7.0, "7", true, nil, map[string]interface{}{"a": 1.0}, []interface{}{2.0}}
+	if !reflect.DeepEqual(decoded["v"], want) {
+		t.Errorf("decoded %#v, want %#v", decoded["v"], want)
+	}
+
+	e, err = extract(`{"v":1}`, map[string]string{"v": "v"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.ResultsJSON(); err == nil {
+		t.Error("encoded without RecordTypes")
+	}
+}
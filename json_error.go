@@ -0,0 +1,73 @@
+package jsonextract
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ParseError describes malformed input. Line and Column are 1-based and
+// Column counts bytes. Path is the matched path enclosing the error, like
+// users[2].address; it is only set when the Extractor records paths.
+type ParseError struct {
+	Offset  int
+	Line    int
+	Column  int
+	Got     TokenType
+	Message string
+	Path    string
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("%s at offset %d (line %d, column %d)", e.Message, e.Offset, e.Line, e.Column)
+	if e.Path != "" {
+		msg += " in " + e.Path
+	}
+	return msg
+}
+
+func newParseError(data []byte, offset int, got TokenType, message string) *ParseError {
+	offset = min(max(offset, 0), len(data))
+	line, column := lineColumn(data, offset)
+	return &ParseError{
+		Offset:  offset,
+		Line:    line,
+		Column:  column,
+		Got:     got,
+		Message: message,
+	}
+}
+
+// lineColumn returns the 1-based line and byte column of offset in data.
+func lineColumn(data []byte, offset int) (line, column int) {
+	line = bytes.Count(data[:offset], []byte{'\n'}) + 1
+	column = offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
+}
+
+// ConversionError reports a result that Unmarshal could not store in a
+// struct field. Index is the position of Value among the field's matches.
+type ConversionError struct {
+	Field string
+	Index int
+	Value string
+	Err   error
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("field %s: value %d %q: %v", e.Field, e.Index, e.Value, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// PathError reports a query rejected by CompilePathsStrict.
+type PathError struct {
+	Name    string
+	Query   string
+	Message string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("path %s %q: %s", e.Name, e.Query, e.Message)
+}
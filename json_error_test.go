@@ -0,0 +1,78 @@
+package jsonextract
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorPosition(t *testing.T) {
+	tests := []struct {
+		name         string
+		doc          string
+		offset       int
+		line, column int
+		got          TokenType
+		message      string
+	}{
+		{"unterminated string", "{\"a\":\n  \"abc", 8, 2, 3, NoToken, "unterminated string"},
+		{"mismatched bracket", "{\"a\":[1,\n 2}", 11, 2, 3, NoToken, "mismatched"},
+		{"array closing object", "[\n{\"a\":1]", 8, 2, 7, EndArray, "expected EndObject"},
+		{"bad literal", "{\"a\":1,\n\"b\":tru}", 12, 2, 5, NoToken, "invalid literal"},
+	}
+	for _, test := range tests {
+		_, err := extract(test.doc, map[string]string{"a": "a", "b": "b"}, nil)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: got %v, want a *ParseError", test.name, err)
+			continue
+		}
+		if perr.Offset != test.offset || perr.Line != test.line || perr.Column != test.column {
+			t.Errorf("%s: offset %d line %d column %d, want %d, %d, %d", test.name,
+				perr.Offset, perr.Line, perr.Column, test.offset, test.line, test.column)
+		}
+		if perr.Got != test.got || !strings.HasPrefix(perr.Message, test.message) {
+			t.Errorf("%s: got %v %q", test.name, perr.Got, perr.Message)
+		}
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	err := &ParseError{Offset: 8, Line: 2, Column: 3, Message: "unterminated string", Path: "a.b"}
+	if got, want := err.Error(), "unterminated string at offset 8 (line 2, column 3) in a.b"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestParseErrorContext(t *testing.T) {
+	tests := []struct {
+		doc, message, path string
+		offset             int
+	}{
+		{`{"users":[{"name":"a"},{"name":"b"]}`, "expected EndObject token, got: EndArray", "users[1]", 34},
+		{`{"a":{"b":1]`, "expected EndObject token, got: EndArray", "a", 11},
+		{`{"a":[1,2}`, "expected EndArray token, got: EndObject", "a", 9},
+	}
+	paths := map[string]string{"n": "users[*].name", "b": "a.b"}
+	for _, test := range tests {
+		for _, record := range []bool{false, true} {
+			_, err := extract(test.doc, paths, func(e *Extractor) { e.RecordPaths = record })
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Errorf("%s: got %v", test.doc, err)
+				continue
+			}
+			want := test.path
+			if !record {
+				want = ""
+			}
+			if perr.Message != test.message || perr.Offset != test.offset || perr.Path != want {
+				t.Errorf("%s: got %q at %d in %q", test.doc, perr.Message, perr.Offset, perr.Path)
+			}
+			if !strings.Contains(err.Error(), "at offset "+strconv.Itoa(test.offset)) {
+				t.Errorf("%s: no offset in %q", test.doc, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package jsonextract
+
+import (
+	"errors"
+	"io"
+)
+
+// FeedExtractor extracts paths from a document that arrives in chunks, such
+// as websocket frames. Chunks are buffered, so a token may straddle any
+// number of Feed calls. The whole document is kept and rescanned from its
+// start each time the buffer has doubled, which keeps feeding linear even one
+// byte at a time: FeedExtractor saves waiting for the rest of the input, not
+// memory.
+type FeedExtractor struct {
+	Lenient       bool // as for Scanner
+	AllowComments bool // as for Scanner
+	// OnResult, when set, is called once for every result as soon as a scan
+	// of the chunks fed so far holds it whole, in document order. Returning
+	// false stops the extraction, as for Extractor.OnResult. Values alias
+	// the buffered document and must not be modified.
+	OnResult func(name string, value []byte) bool
+
+	root    *PathNode
+	buf     []byte
+	scanned int // buffer length at the last extraction attempt
+	emitted int // results already passed to OnResult
+	results map[string][]string
+	done    bool
+}
+
+func NewFeedExtractor(root *PathNode) *FeedExtractor {
+	return &FeedExtractor{root: root}
+}
+
+// Feed appends chunk to the document and reports whether every path is
+// already satisfied, in which case Finish returns without further input.
+func (f *FeedExtractor) Feed(chunk []byte) bool {
+	if f.done {
+		return true
+	}
+	f.buf = append(f.buf, chunk...)
+	if len(f.buf) < 2*f.scanned {
+		return false
+	}
+	f.scanned = len(f.buf)
+
+	e, cut, _ := f.extract(false)
+	if f.done {
+		return true // stopped by OnResult
+	}
+	// a token ending exactly at the end of the buffer, like the 12 of a
+	// coming 123, may still be incomplete
+	if !cut && e.ExtractionComplete && e.Scanner.Pos() < len(f.buf) {
+		f.results = e.Results
+		f.done = true
+	}
+	return f.done
+}
+
+// Finish extracts from everything fed so far, treating it as the whole
+// document.
+func (f *FeedExtractor) Finish() (map[string][]string, error) {
+	if f.done {
+		return f.results, nil
+	}
+	e, _, err := f.extract(true)
+	if err != nil {
+		return e.Results, err
+	}
+	f.results = e.Results
+	f.done = true
+	return f.results, nil
+}
+
+// extract scans the buffer, passing the results OnResult has not seen yet to
+// it. Unless final, a result reaching the end of the buffer may be cut short,
+// so the scan stops there and cut is set.
+func (f *FeedExtractor) extract(final bool) (e *Extractor, cut bool, err error) {
+	e = NewExtractor(f.buf, f.root)
+	e.Scanner.Lenient, e.Scanner.AllowComments = f.Lenient, f.AllowComments
+	if f.OnResult != nil {
+		seen := 0
+		e.OnResult = func(name string, value []byte) bool {
+			if !final && e.Scanner.Pos() >= len(f.buf) {
+				cut = true
+				return false
+			}
+			if seen++; seen <= f.emitted {
+				return true
+			}
+			f.emitted++
+			if !f.OnResult(name, value) {
+				f.results = e.Results
+				f.done = true
+				return false
+			}
+			return true
+		}
+	}
+	err = e.Extract()
+	return e, cut, err
+}
+
+// ExtractReader extracts paths from the JSON document read from r. Reading
+// stops once every path is satisfied, so the rest of r may be left unread.
+func ExtractReader(r io.Reader, paths map[string]string) (map[string][]string, error) {
+	f := NewFeedExtractor(CompilePaths(paths))
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(chunk)
+		if f.Feed(chunk[:n]) || errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f.Finish()
+}
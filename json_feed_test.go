@@ -0,0 +1,176 @@
+package jsonextract
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const feedDoc = `{"user":{"name":"a\"né","age":123},"items":[{"id":1},{"id":22},{"id":333}],"ok":true,"n":null}`
+
+var feedPaths = map[string]string{"name": "user.name", "age": "user.age", "ids": "items[*].id", "ok": "ok", "n": "n"}
+
+func wholeResults(t *testing.T, doc string) map[string][]string {
+	t.Helper()
+	e, err := extract(doc, feedPaths, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.Results
+}
+
+func TestFeedSplitEverywhere(t *testing.T) {
+	want := wholeResults(t, feedDoc)
+	for i := 0; i <= len(feedDoc); i++ {
+		f := NewFeedExtractor(CompilePaths(feedPaths))
+		f.Feed([]byte(feedDoc[:i]))
+		f.Feed([]byte(feedDoc[i:]))
+		got, err := f.Finish()
+		if err != nil {
+			t.Fatalf("split at %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("split at %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestFeedByteAtATime(t *testing.T) {
+	for _, doc := range []string{feedDoc, `{"user":{"age":12` + `3}}`, `{"ok":true}`} {
+		want := wholeResults(t, doc)
+		f := NewFeedExtractor(CompilePaths(feedPaths))
+		for i := range len(doc) {
+			f.Feed([]byte{doc[i]})
+		}
+		got, err := f.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, got, want)
+	}
+}
+
+func TestFeedCompletesEarly(t *testing.T) {
+	f := NewFeedExtractor(CompilePaths(map[string]string{"a": "o.a"}))
+	if f.Feed([]byte(`{"o":{"a":1`)) {
+		t.Error("done before the number could end")
+	}
+	if !f.Feed([]byte(`2},"b":[1,2,3,`)) {
+		t.Error("not done after the value")
+	}
+	got, err := f.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, got, map[string][]string{"a": {"12"}})
+}
+
+func TestFeedTruncated(t *testing.T) {
+	f := NewFeedExtractor(CompilePaths(feedPaths))
+	f.Feed([]byte(feedDoc[:20]))
+	if _, err := f.Finish(); err == nil {
+		t.Error("no error for a truncated document")
+	}
+}
+
+func TestFeedOnResult(t *testing.T) {
+	var want []string
+	e := NewExtractor([]byte(feedDoc), CompilePaths(feedPaths))
+	e.OnResult = func(name string, value []byte) bool {
+		want = append(want, name+"="+string(value))
+		return true
+	}
+	if err := e.Extract(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, split := range []int{1, 7, len(feedDoc)} {
+		var got []string
+		f := NewFeedExtractor(CompilePaths(feedPaths))
+		f.OnResult = func(name string, value []byte) bool {
+			got = append(got, name+"="+string(value))
+			return true
+		}
+		for i := 0; i < len(feedDoc); i += split {
+			f.Feed([]byte(feedDoc[i:min(i+split, len(feedDoc))]))
+		}
+		if split == 1 && len(got) == 0 {
+			t.Error("nothing emitted before Finish")
+		}
+		if _, err := f.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("chunks of %d: emitted %v, want %v", split, got, want)
+		}
+	}
+}
+
+func TestFeedOnResultStops(t *testing.T) {
+	f := NewFeedExtractor(CompilePaths(feedPaths))
+	f.OnResult = func(name string, value []byte) bool { return name != "age" }
+	done := false
+	for i := 0; i < len(feedDoc) && !done; i++ {
+		done = f.Feed([]byte{feedDoc[i]})
+	}
+	if !done {
+		t.Fatal("not done after OnResult returned false")
+	}
+	got, err := f.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, got, map[string][]string{"name": {`a\"né`}, "age": {"123"}})
+}
+
+func TestFeedScannerOptions(t *testing.T) {
+	doc := "{\"a\": 1, // one\n\"b\": [1, 2,],}"
+	f := NewFeedExtractor(CompilePaths(map[string]string{"a": "a", "b": "b[*]"}))
+	f.Lenient, f.AllowComments = true, true
+	for i := range len(doc) {
+		f.Feed([]byte{doc[i]})
+	}
+	got, err := f.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, got, map[string][]string{"a": {"1"}, "b": {"1", "2"}})
+}
+
+func TestExtractReader(t *testing.T) {
+	want := wholeResults(t, feedDoc)
+	readers := map[string]io.Reader{
+		"whole":    strings.NewReader(feedDoc),
+		"one byte": iotest.OneByteReader(strings.NewReader(feedDoc)),
+		"half":     iotest.HalfReader(strings.NewReader(feedDoc)),
+	}
+	for name, r := range readers {
+		got, err := ExtractReader(r, feedPaths)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	if _, err := ExtractReader(iotest.ErrReader(io.ErrUnexpectedEOF), feedPaths); err != io.ErrUnexpectedEOF {
+		t.Errorf("read error: got %v", err)
+	}
+}
+
+func TestExtractReaderStopsEarly(t *testing.T) {
+	// the reader fails a while after the values, so reading to the end
+	// would be an error
+	doc := `{"v":{"a":"x","b":[1,2]},"rest":"` + strings.Repeat("x", 64)
+	r := io.MultiReader(strings.NewReader(doc), iotest.ErrReader(io.ErrUnexpectedEOF))
+	got, err := ExtractReader(iotest.OneByteReader(r), map[string]string{"a": "v.a", "b": "v.b[*]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"a": {"x"}, "b": {"1", "2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package jsonextract
+
+import "maps"
+
+// valueCounts tallies the values of one path under CountValues.
+type valueCounts struct {
+	total  int
+	values map[string]int
+}
+
+func (e *Extractor) countValue(name string, value []byte) {
+	if e.counts == nil {
+		e.counts = make(map[string]*valueCounts)
+	}
+	c := e.counts[name]
+	if c == nil {
+		c = &valueCounts{values: make(map[string]int)}
+		e.counts[name] = c
+	}
+	c.values[string(value)]++
+	c.total++
+}
+
+// Histogram returns how often each value of the path name was extracted,
+// keyed by the value as it would be stored in Results, e.g.
+// {"error": 12, "info": 40} for events[*].level. With CountValues the counts
+// were kept during extraction; otherwise they are counted from Results, or
+// ResultsBytes under ZeroCopy. The map is the caller's to modify.
+func (e *Extractor) Histogram(name string) map[string]int {
+	if e.CountValues {
+		if c := e.counts[name]; c != nil {
+			return maps.Clone(c.values)
+		}
+		return map[string]int{}
+	}
+	counts := make(map[string]int)
+	if e.ZeroCopy {
+		for _, value := range e.ResultsBytes[name] {
+			counts[string(value)]++
+		}
+		return counts
+	}
+	for _, value := range e.Results[name] {
+		counts[value]++
+	}
+	return counts
+}
@@ -0,0 +1,77 @@
+package jsonextract
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// eventsDoc returns n events whose levels repeat in a fixed cycle.
+func eventsDoc(n int) string {
+	levels := []string{"info", "info", "error", "info", "warn"}
+	var b strings.Builder
+	b.WriteString(`{"events":[`)
+	for i := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"level":"%s"}`, i, levels[i%len(levels)])
+	}
+	b.WriteString(`]}`)
+	return b.String()
+}
+
+func TestHistogram(t *testing.T) {
+	doc := eventsDoc(100)
+	paths := map[string]string{"level": "events[*].level", "missing": "events[*].user"}
+	want := map[string]int{"info": 60, "error": 20, "warn": 20}
+	setups := map[string]func(*Extractor){
+		"results":      nil,
+		"zero copy":    func(e *Extractor) { e.ZeroCopy = true },
+		"count values": func(e *Extractor) { e.CountValues = true },
+	}
+	for name, setup := range setups {
+		e, err := extract(doc, paths, setup)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := e.Histogram("level"); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: histogram %v, want %v", name, got, want)
+		}
+		if got := e.Histogram("missing"); len(got) != 0 {
+			t.Errorf("%s: histogram of a path without matches %v", name, got)
+		}
+		e.Histogram("level")["info"] = 0 // the caller's copy
+		if got := e.Histogram("level")["info"]; got != 60 {
+			t.Errorf("%s: histogram changed to %d by its caller", name, got)
+		}
+	}
+}
+
+func TestCountValues(t *testing.T) {
+	e, err := extract(eventsDoc(100), map[string]string{"level": "events[*].level"}, func(e *Extractor) {
+		e.CountValues = true
+		e.MaxResults = 10
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Results["level"]) != 0 {
+		t.Errorf("values stored in Results: %v", e.Results["level"])
+	}
+	if got, want := e.Histogram("level"), map[string]int{"info": 6, "error": 2, "warn": 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("histogram %v, want %v", got, want)
+	}
+
+	e, err = extract(eventsDoc(5), map[string]string{"level": "events[*].level#last"}, func(e *Extractor) {
+		e.CountValues = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.Histogram("level"), map[string]int{"warn": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("#last histogram %v, want %v", got, want)
+	}
+}
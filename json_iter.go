@@ -0,0 +1,28 @@
+package jsonextract
+
+import "iter"
+
+// All runs the extraction and yields every result as it is found, so callers
+// can range over it and break out early to stop the scan. Any OnResult hook
+// already set is replaced. Values alias RawData as with ZeroCopy. An error,
+// such as malformed input or a failing transform, ends the iteration; check
+// Err afterwards.
+func (e *Extractor) All() iter.Seq2[string, []byte] {
+	return func(yield func(name string, value []byte) bool) {
+		stopped := false
+		e.OnResult = func(name string, value []byte) bool {
+			if stopped {
+				return false
+			}
+			stopped = !yield(name, value)
+			return !stopped
+		}
+		e.allErr = e.Extract()
+	}
+}
+
+// Err returns the error that ended the last iteration of All, or nil if it
+// ran to the end or the caller broke out of it.
+func (e *Extractor) Err() error {
+	return e.allErr
+}
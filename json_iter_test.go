@@ -0,0 +1,80 @@
+package jsonextract
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	e := NewExtractor([]byte(`{"a":[1,2],"b":"x"}`), CompilePaths(map[string]string{"a": "a[*]", "b": "b"}))
+	var got []string
+	for name, value := range e.All() {
+		got = append(got, name+"="+string(value))
+	}
+	if want := []string{"a=1", "a=2", "b=x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("yielded %v, want %v", got, want)
+	}
+	if err := e.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllBreak(t *testing.T) {
+	// the document is malformed after the second item, so reading on fails
+	data := []byte(`{"items":[1,2,3,4,}`)
+	e := NewExtractor(data, CompilePaths(map[string]string{"item": "items[*]"}))
+	var got []string
+	for _, value := range e.All() {
+		got = append(got, string(value))
+		if len(got) == 2 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("yielded %v", got)
+	}
+	if !e.ExtractionComplete {
+		t.Error("extraction did not stop")
+	}
+	if err := e.Scanner.Err(); err != nil {
+		t.Errorf("scan went on after break: %v", err)
+	}
+	if err := e.Err(); err != nil {
+		t.Errorf("Err() = %v after break", err)
+	}
+	if pos := e.Scanner.Pos(); pos > len(`{"items":[1,2`) {
+		t.Errorf("scanned to offset %d after break", pos)
+	}
+}
+
+func TestAllErr(t *testing.T) {
+	errBad := errors.New("bad value")
+	e := NewExtractor([]byte(`{"n":[1,2,3]}`), CompilePaths(map[string]string{"n": "n[*]"}))
+	e.Transforms = map[string]TransformFunc{
+		"n": func(b []byte) ([]byte, error) {
+			if string(b) == "2" {
+				return nil, errBad
+			}
+			return b, nil
+		},
+	}
+	var got []string
+	for _, value := range e.All() {
+		got = append(got, string(value))
+	}
+	if !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("yielded %v", got)
+	}
+	if err := e.Err(); !errors.Is(err, errBad) {
+		t.Errorf("Err() = %v, want the transform's error", err)
+	}
+
+	// a malformed document, found after the last match
+	e = NewExtractor([]byte(`{"n":[1],"z":}`), CompilePaths(map[string]string{"n": "n[*]", "z": "z"}))
+	for range e.All() {
+	}
+	if e.Err() == nil {
+		t.Error("no error for a malformed document")
+	}
+}
@@ -0,0 +1,133 @@
+package jsonextract
+
+import "testing"
+
+func TestMaxResults(t *testing.T) {
+	e, err := extract(`{"a":[1,2,3,4],"b":[5,6,7]}`, map[string]string{"a": "a[*]", "b": "b[*]"}, func(e *Extractor) {
+		e.MaxResults = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1", "2"}, "b": {"5", "6"}})
+	if !e.ExtractionComplete {
+		t.Error("extraction went on after every path reached its cap")
+	}
+}
+
+func TestResultLimits(t *testing.T) {
+	e, err := extract(`{"a":[1,2,3,4],"b":[5,6,7]}`, map[string]string{"a": "a[*]", "b": "b[*]"}, func(e *Extractor) {
+		e.ResultLimits = map[string]int{"a": 1}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1"}, "b": {"5", "6", "7"}})
+
+	e, err = extract(`{"a":[1,2,3],"b":[5,6,7]}`, map[string]string{"a": "a[*]", "b": "b[*]"}, func(e *Extractor) {
+		e.MaxResults = 1
+		e.ResultLimits = map[string]int{"b": 0} // unlimited despite MaxResults
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1"}, "b": {"5", "6", "7"}})
+}
+
+func TestCappedPathsEndExtraction(t *testing.T) {
+	// once every path is capped the malformed rest is never read
+	e, err := extract(`{"a":[1,2,3,`, map[string]string{"a": "a[*]"}, func(e *Extractor) {
+		e.MaxResults = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1", "2"}})
+
+	// an uncapped path keeps reading, and finds the error
+	_, err = extract(`{"a":[1,2,3,`, map[string]string{"a": "a[*]", "b": "b"}, func(e *Extractor) {
+		e.MaxResults = 2
+	})
+	if err == nil {
+		t.Error("no error with a path still open")
+	}
+}
+
+func TestCapUnderNestedWildcards(t *testing.T) {
+	e, err := extract(`{"o":[{"i":[1,2]},{"i":[3]},{"i":[4,5]}]}`, map[string]string{"i": "o[*].i[*]"}, func(e *Extractor) {
+		e.MaxResults = 4
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"i": {"1", "2", "3", "4"}})
+}
+
+func TestFirstAndLast(t *testing.T) {
+	doc := `{"logs":[{"ts":1},{"ts":2},{"x":0},{"ts":3}]}`
+	e, err := extract(doc, map[string]string{"first": "logs[*].ts#first", "last": "logs[*].ts#last"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"first": {"1"}, "last": {"3"}})
+
+	// a cap does not stop #last at the first value
+	e, err = extract(doc, map[string]string{"last": "logs[*].ts#last"}, func(e *Extractor) {
+		e.MaxResults = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"last": {"3"}})
+}
+
+func TestFirstCompletesEarly(t *testing.T) {
+	// the malformed rest is never read once every path has its value
+	e, err := extract(`{"logs":[{"ts":1},{"ts":2},`, map[string]string{"ts": "logs[*].ts#first"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"ts": {"1"}})
+	if !e.ExtractionComplete {
+		t.Error("extraction did not complete early")
+	}
+
+	// #last has to read to the end
+	if _, err := extract(`{"logs":[{"ts":1},{"ts":2},`, map[string]string{"ts": "logs[*].ts#last"}, nil); err == nil {
+		t.Error("#last completed before the end of the array")
+	}
+}
+
+func TestMaxMatchedPaths(t *testing.T) {
+	// the document is cut short after "c", so only an early stop succeeds
+	doc := `{"a":[1,2],"b":3,"c":4,"d":`
+	paths := map[string]string{"a": "a[*]", "b": "b", "c": "c", "d": "d"}
+	tests := []struct {
+		max  int
+		want map[string][]string
+	}{
+		{1, map[string][]string{"a": {"1"}}},
+		{2, map[string][]string{"a": {"1", "2"}, "b": {"3"}}},
+		{3, map[string][]string{"a": {"1", "2"}, "b": {"3"}, "c": {"4"}}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, paths, func(e *Extractor) { e.MaxMatchedPaths = test.max })
+		if err != nil {
+			t.Errorf("max %d: %v", test.max, err)
+			continue
+		}
+		checkResults(t, e.Results, test.want)
+		if !e.ExtractionComplete {
+			t.Errorf("max %d: extraction did not stop early", test.max)
+		}
+	}
+
+	// more than the number of paths: no limit at all
+	e, err := extract(`{"a":[1],"b":2}`, map[string]string{"a": "a[*]", "b": "b"}, func(e *Extractor) {
+		e.MaxMatchedPaths = 5
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1"}, "b": {"2"}})
+}
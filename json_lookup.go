@@ -0,0 +1,81 @@
+package jsonextract
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ExtractByValue returns the path of every scalar in data equal to value, in
+// document order and written like the Paths of RecordPaths, e.g.
+// users[1].address.city. Values compare as in a [?=value] filter: strings
+// after unescaping and numbers by value, so the comparison is not type-aware
+// and "42" finds both 42 and "42".
+func ExtractByValue(data []byte, value string) ([]string, error) {
+	f := &valueFinder{target: PathFilter{Op: "=", Value: value}}
+	err := Scan(data, f)
+	return f.paths, err
+}
+
+// valueFinder tracks the path of the value being walked.
+type valueFinder struct {
+	target PathFilter
+	frames []pathFrame // one per open object or array
+	paths  []string
+}
+
+type pathFrame struct {
+	array bool
+	index int    // element being read, for arrays
+	key   string // member being read, for objects
+}
+
+func (f *valueFinder) OnStartObject() {
+	f.frames = append(f.frames, pathFrame{})
+}
+
+func (f *valueFinder) OnKey(key []byte) {
+	f.frames[len(f.frames)-1].key = string(unescaped(key)) // as in the Paths of RecordPaths
+}
+
+func (f *valueFinder) OnEndObject() {
+	f.frames = f.frames[:len(f.frames)-1]
+	f.advance()
+}
+
+func (f *valueFinder) OnStartArray() {
+	f.frames = append(f.frames, pathFrame{array: true})
+}
+
+func (f *valueFinder) OnEndArray() {
+	f.frames = f.frames[:len(f.frames)-1]
+	f.advance()
+}
+
+func (f *valueFinder) OnValue(t TokenType, value []byte) {
+	if f.target.compare(t, value) {
+		f.paths = append(f.paths, f.path())
+	}
+	f.advance()
+}
+
+// advance moves past a finished array element.
+func (f *valueFinder) advance() {
+	if n := len(f.frames); n > 0 && f.frames[n-1].array {
+		f.frames[n-1].index++
+	}
+}
+
+func (f *valueFinder) path() string {
+	var b strings.Builder
+	for _, frame := range f.frames {
+		if frame.array {
+			b.WriteString("[" + strconv.Itoa(frame.index) + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(frame.key)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package jsonextract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractByValue(t *testing.T) {
+	doc := []byte(`{"users":[{"name":"ann","address":{"city":"Oslo"}},{"name":"bob","home":{"city":"Oslo"}}],
+	"hq":"Oslo","n":42,"s":"42","f":42.0,"esc":"Oslo","a\/b":{"c\"d":"Oslo"}}`)
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"Oslo", []string{"users[0].address.city", "users[1].home.city", "hq", "esc", `a/b.c"d`}},
+		{"42", []string{"n", "s", "f"}},
+		{"bob", []string{"users[1].name"}},
+		{"nobody", nil},
+	}
+	for _, test := range tests {
+		got, err := ExtractByValue(doc, test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ExtractByValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestExtractByValueMatchesRecordPaths(t *testing.T) {
+	doc := `{"a\/b":{"x":7},"l":[[1,{"key":7}]]}`
+	got, err := ExtractByValue([]byte(doc), "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := extract(doc, map[string]string{"x": "a/b.x", "key": "l[0][1].key"}, func(e *Extractor) {
+		e.RecordPaths = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{e.Paths["x"][0], e.Paths["key"][0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractByValue gave %q, RecordPaths %q", got, want)
+	}
+}
+
+func TestExtractByValueError(t *testing.T) {
+	if _, err := ExtractByValue([]byte(`{"a":[1,`), "1"); err == nil {
+		t.Error("no error for a truncated document")
+	}
+}
@@ -0,0 +1,23 @@
+package jsonextract
+
+import "bytes"
+
+// KeyMatcher decides whether a document key matches a key from a query.
+// Set one on Extractor.KeyMatcher to replace exact comparison.
+type KeyMatcher interface {
+	Match(queryKey, docKey []byte) bool
+}
+
+// ExactMatcher matches identical keys, the default.
+type ExactMatcher struct{}
+
+func (ExactMatcher) Match(queryKey, docKey []byte) bool {
+	return bytes.Equal(queryKey, docKey)
+}
+
+// CaseInsensitiveMatcher matches keys equal under Unicode case folding.
+type CaseInsensitiveMatcher struct{}
+
+func (CaseInsensitiveMatcher) Match(queryKey, docKey []byte) bool {
+	return bytes.EqualFold(queryKey, docKey)
+}
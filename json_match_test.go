@@ -0,0 +1,35 @@
+package jsonextract
+
+import (
+	"bytes"
+	"testing"
+)
+
+// camelMatcher treats snake_case and camelCase spellings of a key as equal.
+type camelMatcher struct{}
+
+func (camelMatcher) Match(queryKey, docKey []byte) bool {
+	return bytes.EqualFold(bytes.ReplaceAll(queryKey, []byte("_"), nil), bytes.ReplaceAll(docKey, []byte("_"), nil))
+}
+
+func TestKeyMatcher(t *testing.T) {
+	doc := `{"userId":1,"user":{"Display_Name":"ann"},"items":[{"item_id":2}]}`
+	paths := map[string]string{"id": "user_id", "name": "user.display_name", "item": "items[*].itemId"}
+	tests := []struct {
+		name    string
+		matcher KeyMatcher
+		want    map[string][]string
+	}{
+		{"default", nil, nil},
+		{"exact", ExactMatcher{}, nil},
+		{"case-insensitive", CaseInsensitiveMatcher{}, map[string][]string{"name": {"ann"}}},
+		{"custom", camelMatcher{}, map[string][]string{"id": {"1"}, "name": {"ann"}, "item": {"2"}}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, paths, func(e *Extractor) { e.KeyMatcher = test.matcher })
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkResults(t, e.Results, test.want)
+	}
+}
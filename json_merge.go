@@ -0,0 +1,69 @@
+package jsonextract
+
+// MergeTrees returns a tree extracting every path of a and of b, as if all
+// their queries had been compiled together. Shared prefixes become shared
+// nodes, while segments that differ, such as items[2] and items[3], stay
+// separate children. a and b are not modified. A terminal that both trees
+// reach under different names is kept twice, so each name still gets its
+// results, except at the root itself where the name from a wins; one reached
+// under the same name is kept once.
+func MergeTrees(a, b *PathNode) *PathNode {
+	merged := a.clone()
+	merged.merge(b)
+
+	var names []string
+	merged.collectNames(&names)
+	merged.NumTerminals = len(names)
+	merged.markRepeated(false)
+	merged.linkDescent()
+	merged.linkGroups(nil)
+	return merged
+}
+
+// clone copies the tree below n. Descent nodes and Groups are left out for
+// linkDescent and linkGroups to rebuild over the copied children.
+func (n *PathNode) clone() *PathNode {
+	c := *n
+	c.Descent = nil
+	c.Groups = nil
+	c.Children = make([]*PathNode, len(n.Children))
+	for i, child := range n.Children {
+		c.Children[i] = child.clone()
+	}
+	return &c
+}
+
+// merge adds the paths below other to n, the way addPath would.
+func (n *PathNode) merge(other *PathNode) {
+	if other.IsTerminal && !n.IsTerminal {
+		n.Name = other.Name
+		n.IsTerminal = true
+		n.First, n.Last = other.First, other.Last
+		n.Presence = other.Presence
+		n.Aggregate = other.Aggregate
+		n.Group = other.Group
+		n.Keys = other.Keys
+	}
+	for _, child := range other.Children {
+		existing, found := n.findSegment(child.Segment)
+		if found && child.IsTerminal && existing.IsTerminal {
+			existing, found = n.findTerminal(child.Segment, child.Name)
+		}
+		if !found {
+			n.Children = append(n.Children, child.clone())
+			continue
+		}
+		existing.merge(child)
+	}
+}
+
+// findTerminal returns the child compiled from segment that is the terminal
+// for name, if there is one.
+func (n *PathNode) findTerminal(segment, name string) (*PathNode, bool) {
+	for _, child := range n.Children {
+		if child.Segment == segment && child.IsTerminal && child.Name == name {
+			return child, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,55 @@
+package jsonextract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeTrees(t *testing.T) {
+	doc := `{"user":{"name":"ann","address":{"city":"Oslo","zip":"0150"}},"items":[{"id":1},{"id":2},{"id":3},{"id":4}],"v":9}`
+	tests := []struct {
+		name string
+		a, b map[string]string
+	}{
+		{"shared prefix", map[string]string{"name": "user.name"}, map[string]string{"city": "user.address.city", "zip": "user.address.zip"}},
+		{"different index", map[string]string{"third": "items[2].id"}, map[string]string{"fourth": "items[3].id"}},
+		{"index and filter", map[string]string{"ids": "items[*].id"}, map[string]string{"two": "items[?id=2].id", "first": "items[0].id"}},
+		{"disjoint", map[string]string{"name": "user.name"}, map[string]string{"v": "v"}},
+		{"same path", map[string]string{"a": "user.name"}, map[string]string{"b": "user.name"}},
+		{"same name and path", map[string]string{"a": "user.name"}, map[string]string{"a": "user.name"}},
+		{"same name and top-level path", map[string]string{"v": "v"}, map[string]string{"v": "v"}},
+	}
+	for _, test := range tests {
+		union := make(map[string]string)
+		for name, query := range test.a {
+			union[name] = query
+		}
+		for name, query := range test.b {
+			union[name] = query
+		}
+		want, err := extract(doc, union, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, b := CompilePaths(test.a), CompilePaths(test.b)
+		e := NewExtractor([]byte(doc), MergeTrees(a, b))
+		if err := e.Extract(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(e.Results, want.Results) {
+			t.Errorf("%s: got %v, want %v", test.name, e.Results, want.Results)
+		}
+
+		// a still extracts only its own paths
+		onlyA := NewExtractor([]byte(doc), a)
+		if err := onlyA.Extract(); err != nil {
+			t.Fatal(err)
+		}
+		for name := range onlyA.Results {
+			if _, ok := test.a[name]; !ok {
+				t.Errorf("%s: merging modified a, which now extracts %s", test.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package jsonextract
+
+// NestedResults arranges the collected results in maps mirroring the compiled
+// path tree, so user.name and user.address.city become
+// {"user": {"name": ..., "address": {"city": ...}}}. A terminal that can match
+// more than once (below a wildcard or filter) holds a []interface{} of its
+// values, any other terminal holds its single value as a string. Chained
+// indices keep their bracket as the key, e.g. m[1][2] gives {"m": {"[2]": ...}}.
+// Terminals without results are left out, and a terminal wins over paths that
+// continue below it since its raw value already contains them.
+func (e *Extractor) NestedResults() map[string]interface{} {
+	out := make(map[string]interface{})
+	e.nestResults(e.Root, out)
+	return out
+}
+
+func (e *Extractor) nestResults(node *PathNode, out map[string]interface{}) {
+	for _, child := range node.Children {
+		key := string(child.Key)
+		if child.Nested {
+			key = child.Segment
+		} else if child.AnyKey {
+			key += "*"
+		}
+
+		if child.IsTerminal {
+			values := e.resultStrings(child.Name)
+			if len(values) == 0 {
+				continue
+			}
+			if child.Repeated {
+				list := make([]interface{}, len(values))
+				for i, v := range values {
+					list[i] = v
+				}
+				out[key] = list
+			} else {
+				out[key] = values[0]
+			}
+			continue
+		}
+
+		sub, ok := out[key].(map[string]interface{})
+		if !ok {
+			if _, taken := out[key]; taken {
+				continue
+			}
+			sub = make(map[string]interface{})
+		}
+		e.nestResults(child, sub)
+		if len(sub) > 0 {
+			out[key] = sub
+		}
+	}
+}
+
+func (e *Extractor) resultStrings(name string) []string {
+	if !e.ZeroCopy {
+		return e.Results[name]
+	}
+	values := make([]string, len(e.ResultsBytes[name]))
+	for i, v := range e.ResultsBytes[name] {
+		values[i] = string(v)
+	}
+	return values
+}
+
+// objectMembers splits the raw JSON of an object into its members.
+func objectMembers(raw []byte) []Member {
+	s := NewScanner(&raw)
+	s.MaxDepth = -1 // raw was read within the extractor's limit already
+	s.Token()       // the opening brace
+	members := []Member{}
+	for s.More() {
+		key, err := s.ExpectString()
+		if err != nil {
+			break
+		}
+		if s.peek() == ':' {
+			s.pos++ // skip colon
+		}
+		s.skipWhitespace()
+		start := s.pos
+		tok, _ := s.Token()
+		s.pos = start
+		s.SkipValue()
+		members = append(members, Member{Key: string(unescaped(key)), Value: raw[start:s.pos], Type: tok})
+	}
+	return members
+}
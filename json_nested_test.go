@@ -0,0 +1,86 @@
+package jsonextract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNestedResults(t *testing.T) {
+	doc := `{"user":{"name":"ann","address":{"city":"Oslo","zip":"0150"}},
+	"items":[{"id":1},{"id":2}],"m":[[1,2]]}`
+	tests := []struct {
+		name  string
+		paths map[string]string
+		want  map[string]interface{}
+	}{
+		{
+			"scalars",
+			map[string]string{"name": "user.name", "city": "user.address.city", "missing": "user.phone"},
+			map[string]interface{}{"user": map[string]interface{}{
+				"name":    "ann",
+				"address": map[string]interface{}{"city": "Oslo"},
+			}},
+		},
+		{
+			"wildcard",
+			map[string]string{"ids": "items[*].id"},
+			map[string]interface{}{"items": map[string]interface{}{"id": []interface{}{"1", "2"}}},
+		},
+		{
+			"chained index",
+			map[string]string{"m": "m[0][1]"},
+			map[string]interface{}{"m": map[string]interface{}{"[1]": "2"}},
+		},
+		{
+			"terminal wins",
+			map[string]string{"address": "user.address", "city": "user.address.city"},
+			map[string]interface{}{"user": map[string]interface{}{"address": `{"city":"Oslo","zip":"0150"}`}},
+		},
+		{
+			"nothing found",
+			map[string]string{"x": "user.x.y"},
+			map[string]interface{}{},
+		},
+	}
+	for _, test := range tests {
+		for _, zeroCopy := range []bool{false, true} {
+			e, err := extract(doc, test.paths, func(e *Extractor) { e.ZeroCopy = zeroCopy })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.NestedResults(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s (ZeroCopy %v): got %#v, want %#v", test.name, zeroCopy, got, test.want)
+			}
+		}
+	}
+}
+
+func TestRecordMembers(t *testing.T) {
+	doc := `{"o":{"z":1, "a" : "x\"y","m":{"k":[1]},"l":[{},2],"t":true,"n":null,"e\/k":-1.5},
+	"list":[{"b":2,"a":1},3,{}]}`
+	e, err := extract(doc, map[string]string{"o": "o", "list": "list[*]"}, func(e *Extractor) {
+		e.RecordMembers = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][][]Member{
+		"o": {{
+			{"z", []byte("1"), Number},
+			{"a", []byte(`"x\"y"`), String},
+			{"m", []byte(`{"k":[1]}`), StartObject},
+			{"l", []byte(`[{},2]`), StartArray},
+			{"t", []byte("true"), Boolean},
+			{"n", []byte("null"), Null},
+			{"e/k", []byte("-1.5"), Number},
+		}},
+		"list": {
+			{{"b", []byte("2"), Number}, {"a", []byte("1"), Number}},
+			nil, // not an object
+			{},
+		},
+	}
+	if !reflect.DeepEqual(e.Members, want) {
+		t.Errorf("members %v, want %v", e.Members, want)
+	}
+}
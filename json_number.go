@@ -0,0 +1,36 @@
+package jsonextract
+
+import (
+	"bytes"
+	"math/big"
+	"strconv"
+)
+
+// NumbersEqual reports whether a and b hold the same number, ignoring
+// formatting, so 1.0 equals 1 and 1e2 equals 100. Surrounding whitespace is
+// ignored. Values that are not numbers are never equal.
+func NumbersEqual(a, b []byte) bool {
+	x, ok := parseNumber(a)
+	if !ok {
+		return false
+	}
+	y, ok := parseNumber(b)
+	return ok && x.Cmp(y) == 0
+}
+
+// parseNumber parses b exactly, without the rounding of a float64. Huge
+// exponents, which would need huge rationals, go through a float64 instead.
+func parseNumber(b []byte) (*big.Rat, bool) {
+	b = bytes.TrimSpace(b)
+	if !validNumber(bytes.TrimPrefix(b, []byte("+"))) {
+		return nil, false
+	}
+	if i := bytes.IndexAny(b, "eE"); i >= 0 {
+		if exp, err := strconv.Atoi(string(b[i+1:])); err != nil || exp > 400 || exp < -400 {
+			f, _ := strconv.ParseFloat(string(b), 64)
+			r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+			return r, ok
+		}
+	}
+	return new(big.Rat).SetString(string(b))
+}
@@ -0,0 +1,38 @@
+package jsonextract
+
+import "testing"
+
+func TestNumbersEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1.0", "1", true},
+		{"1e2", "100", true},
+		{"1E+2", "100.000", true},
+		{" 5 ", "5", true},
+		{"-0", "0", true},
+		{"0.1", "0.2", false},
+		{"9007199254740993", "9007199254740992", false}, // equal as float64
+		{"1", "x", false},
+		{"01", "1", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		if got := NumbersEqual([]byte(test.a), []byte(test.b)); got != test.want {
+			t.Errorf("NumbersEqual(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestFilterComparesNumbersByValue(t *testing.T) {
+	e, err := extract(`{"l":[{"v":1.0,"id":"a"},{"v":100,"id":"b"},{"v":"x","id":"c"}]}`, map[string]string{
+		"one":     "l[?v=1].id",
+		"hundred": "l[?v=1e2].id",
+		"not":     "l[?v!=1].id",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"one": {"a"}, "hundred": {"b"}, "not": {"b", "c"}})
+}
@@ -2,26 +2,68 @@ package jsonextract
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 type PathNode struct {
 	Name         string
-	Key          []byte // the json key value to match for this node
+	Segment      string         // the query segment this node was compiled from
+	Key          []byte         // the json key value to match for this node
+	Alternatives [][]byte       // keys of an (a|b) group; only the first present per object matches
+	AnyKey       bool           // trailing '*': matches every key starting with Key
+	Pattern      *regexp.Regexp // /pattern/ key: matches every key the expression matches
+	Embedded     bool           // trailing '~': a string value is decoded and matched as JSON
 	Children     []*PathNode
 	Filter       *PathFilter
-	ArrayIndex   int // -1 means wildcard (all)
+	ArrayIndex   int     // -1 means wildcard (all)
+	FromEnd      int     // [-2]: the element 2 from the end, counted per array read
+	MatchIndex   int     // with MatchIndexed, the index among the filter's matches; negative counts from the end
+	MatchIndexed bool    // a filter followed by an index, e.g. [?status=active][0]
+	Neighbor     int     // a filter followed by an offset, e.g. [?id=5]+1: the element that far from each match
+	SampleRate   float64 // '*~0.1': keep each match with this probability
+	SampleSize   int     // '*~100': keep this many matches, chosen uniformly
 	AsArray      bool
-	IsTerminal   bool // true if this node is a terminal node in the path
+	Nested       bool        // index-only step into an element that is itself an array, e.g. the [2] in m[1][2]
+	Length       bool        // '#' segment: the number of elements of the array above
+	Meta         string      // "length", "type" or "keys" for an @length, @type or @keys segment
+	Recursive    bool        // preceded by '..': matches at any depth below its parent
+	Descent      *PathNode   // the Recursive children, matched again inside every nested value
+	DescentDepth int         // '..{3}': match at most 3 keys below the parent, inclusive, not counting array steps; 0 for any depth
+	IsTerminal   bool        // true if this node is a terminal node in the path
+	First        bool        // #first: keep only the first match
+	Last         bool        // #last: keep only the final match
+	Keys         bool        // #keys: record the key the innermost '*' or /pattern/ matched, not the value
+	Presence     bool        // trailing '?': only record whether the path exists
+	Aggregate    bool        // #array: join the raw JSON of every match into one array result
+	Group        bool        // #group: like #array, but one array per element of the outermost array above
+	Groups       []*PathNode // the #group terminals collected per element of this array
+	Repeated     bool        // true if this node or an ancestor can match more than once
+	InRepeated   bool        // true if an ancestor can match more than once
 	NumTerminals int
 }
 
 type PathResultWatcher struct {
 	Name     string
-	Children map[string]*PathResultWatcher
+	Terminal bool
+	Children map[*PathNode]*PathResultWatcher
 	Complete bool
+	// Short is set when an array was read for the node's index but ended
+	// before it; Length is that array's element count.
+	Short  bool
+	Length int
+	// matched is set once a single-valued member matched; it completes at
+	// the end of the enclosing object, as the key may be given again.
+	matched bool
 }
 
 func (n *PathNode) String() string {
@@ -40,10 +82,56 @@ func (n *PathNode) String() string {
 }
 
 type PathFilter struct {
+	Key    string // empty to compare the element itself
+	Op     string // one of = != < <= > >=
+	Value  string
+	Ref    string // '@field' value: compare against this field of the same element
+	Subset bool   // Value is a JSON object or array the compared value must contain
+	Type   bool   // [?@type=number]: Value names the JSON type of the element
+}
+
+// jsonTypes are the names a @type filter accepts.
+var jsonTypes = []string{"object", "array", "string", "number", "boolean", "null"}
+
+// jsonType names the type of the value starting with tok.
+func jsonType(tok TokenType) string {
+	switch tok {
+	case StartObject:
+		return "object"
+	case StartArray:
+		return "array"
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case Boolean:
+		return "boolean"
+	case Null:
+		return "null"
+	}
+	return ""
+}
+
+type TransformFunc func([]byte) ([]byte, error)
+
+// KeyValue is a result paired with the object key it was found under.
+type KeyValue struct {
 	Key   string
 	Value string
 }
 
+// RecordFields holds the #group arrays of the elements sharing a GroupKey
+// value, by result name, as raw JSON like the arrays in Results.
+type RecordFields map[string]string
+
+// Member is one key and value of an object result. Value is the raw JSON
+// of the value, so strings keep their quotes and escapes.
+type Member struct {
+	Key   string
+	Value []byte
+	Type  TokenType
+}
+
 type Extractor struct {
 	RawData            []byte
 	Root               *PathNode
@@ -51,66 +139,415 @@ type Extractor struct {
 	Scanner            *Scanner
 	ResultWatcher      *PathResultWatcher
 	ExtractionComplete bool
+	MaxResults         int                      // per-path result cap, 0 means unlimited
+	MaxMatchedPaths    int                      // stop once this many result names matched, 0 means no limit
+	ResultLimits       map[string]int           // per-path caps overriding MaxResults
+	SizeHint           int                      // expected results per path, used to preallocate result slices
+	SizeHints          map[string]int           // per-path hints overriding SizeHint
+	Defaults           map[string]string        // per-path values stored after Extract when a path matched nothing
+	Trace              io.Writer                // when set, receives a line per key, element and result visited
+	Transforms         map[string]TransformFunc // per-path hooks applied before storing a value
+	StrictNumbers      bool                     // reject malformed numbers and strip a leading '+'
+	UnescapeStrings    bool                     // store string results with their escapes decoded
+	Verbatim           bool                     // store results, and @keys keys, exactly as in the source, strings quoted; checks still apply
+	NormalizeKeys      bool                     // compare keys after NFC normalization
+	KeyMatcher         KeyMatcher               // compares query and document keys, exact when nil
+	RecordPaths        bool                     // record the concrete path of every result in Paths, and of parse errors
+	// ZeroCopy stores results in ResultsBytes instead of Results. The slices
+	// alias RawData (unless a transform replaced them), so they are only valid
+	// while RawData is kept alive and unmodified.
+	ZeroCopy     bool
+	ResultsBytes map[string][][]byte
+	// Arena, with ZeroCopy, receives a copy of every result, so results
+	// outlive RawData, as when it is a reused read buffer, without an
+	// allocation each. They are valid until the Arena is reset.
+	Arena       *Arena
+	Presence    map[string]bool     // set for paths compiled with a trailing '?'
+	Paths       map[string][]string // realized paths, parallel to Results
+	RecordTypes bool                // record the token type of every result in Types
+	Types       map[string][]TokenType
+	// RecordDepths records how deeply every result is nested in Depths,
+	// counting the objects and arrays around it: 0 for the root value, 1 for
+	// its members or elements, and so on. An @length, @type or @keys result
+	// has the depth of the value it describes, and values inside a '~'
+	// string count the string's own nesting on top.
+	RecordDepths bool
+	Depths       map[string][]int
+	// NormalizeNumbers stores numbers in the shortest form that parses back
+	// to the same float64, so 1.50, 1.5 and 15e-1 are all 1.5. This is lossy
+	// beyond float64 precision: 12345678901234567891 becomes
+	// 1.2345678901234567e+19. Verbatim takes precedence.
+	NormalizeNumbers bool
+	// CountValues tallies the values of every path for Histogram instead of
+	// storing them, leaving Results empty, so memory grows with the number of
+	// distinct values rather than of matches. Result limits, #first and #last
+	// apply to the matches counted.
+	CountValues bool
+	counts      map[string]*valueCounts
+	// RecordMembers splits every object result into its members, in
+	// document order, in Members. Entries for other results are nil.
+	RecordMembers bool
+	Members       map[string][][]Member
+	// OnResult is called with every stored result; returning false stops the
+	// extraction as if every path had completed.
+	OnResult func(name string, value []byte) bool
+	// OnMissing is called after a successful Extract with the sorted names
+	// of the paths that matched nothing, if there are any. Defaults do not
+	// count as matches.
+	OnMissing func(names []string)
+	// CapturePairs records every result together with the key matched by
+	// the nearest '*' or /pattern/ segment above it in Pairs.
+	CapturePairs bool
+	Pairs        map[string][]KeyValue
+	// GroupKey names a member of the elements a #group path groups by,
+	// such as an order's id. The element's #group arrays are then also
+	// stored in Grouped under its value of that member: a string unescaped,
+	// a number or boolean as written. An element without the member, or with
+	// null, an object or an array there, is only in Results.
+	GroupKey string
+	// Grouped maps each GroupKey value to the fields of the elements that
+	// have it. A later element with the same value adds its matches to the
+	// arrays of the earlier one. Elements without a value are left out, so
+	// they are only found in Results.
+	Grouped map[string]RecordFields
+	// ScalarWildcards makes a terminal '*' segment, as in config.*, skip
+	// object and array values. By default they are captured as raw JSON next
+	// to the scalars; use config..* to reach the values nested inside them.
+	ScalarWildcards bool
+	// Rand drives '*~' sampling; set it to a seeded source for repeatable
+	// samples. When nil the global source is used.
+	Rand *rand.Rand
+	// CollectErrors records values that cannot be stored, such as a number
+	// rejected by StrictNumbers or a failed transform, in Errors and carries
+	// on without them. Malformed structure still ends the extraction.
+	CollectErrors bool
+	errs          []error
+	allErr        error // the error that ended the last All iteration
+	// Partial keeps the results stored before a malformed part of the
+	// document ended the extraction usable next to the error: #array paths
+	// get the array of the matches read so far. Defaults are not applied,
+	// and a #group element cut short has no group.
+	Partial bool
+	// IgnoreKeys names object members that are skipped unread wherever they
+	// appear, even when a path or '..' would match inside them. Keys are
+	// compared after unescaping.
+	IgnoreKeys map[string]bool
+	// ScanAll validates the whole root value once every path completed,
+	// rather than stopping early, so malformed input is always reported.
+	// Validation is stricter than extraction, as Validate is.
+	ScanAll     bool
+	scannedAll  bool
+	wildKey     []byte // key matched by the innermost '*' or /pattern/ segment
+	rootStart   int
+	aggregates  map[string][]byte // #array results being built
+	matched     map[string]bool   // names that matched at least once, for Coverage
+	occurrences map[*PathNode]int // matches so far of '..' nodes selecting one occurrence
+	descended   int               // keys descended through since the last match, for '..{n}'
+	pathStack   []string
 }
 
+// CompilePaths compiles named queries into one tree, so a single pass over a
+// document extracts them all. A query is a dot-separated list of keys, so a
+// key that contains dots has to be quoted: `data."metric.cpu."*` matches the
+// keys of data starting with metric.cpu., while `data.metric.cpu.*` steps
+// into nested metric and cpu objects.
 func CompilePaths(paths map[string]string) *PathNode {
 	root := &PathNode{}
 	terminals := 0
 	for name, query := range paths {
-		segments := strings.Split(query, ".")
-		current := root
-		for _, segment := range segments {
-			child, found := current.FindChildByName(segment)
-			if !found {
-				child = &PathNode{Name: segment}
-				child.Key = []byte(segment)
-				current.Children = append(current.Children, child)
+		if root.addPath(name, query) {
+			terminals++
+		}
+	}
+	root.NumTerminals = terminals
+	root.markRepeated(false)
+	root.linkDescent()
+	root.linkGroups(nil)
+	return root
+}
+
+// CompilePathsMulti compiles several queries per result name, so matches from
+// different places in the document are collected, in document order, under
+// one name.
+func CompilePathsMulti(paths map[string][]string) *PathNode {
+	root := &PathNode{}
+	terminals := 0
+	for name, queries := range paths {
+		for _, query := range queries {
+			if root.addPath(name, query) {
+				terminals++
 			}
+		}
+	}
+	root.NumTerminals = terminals
+	root.markRepeated(false)
+	root.linkDescent()
+	root.linkGroups(nil)
+	return root
+}
+
+// addPath compiles query below n as a terminal called name, reporting
+// whether a terminal was added.
+func (n *PathNode) addPath(name, query string) bool {
+	query = strings.TrimSpace(query)
+	query, aggregate := strings.CutSuffix(query, "#array")
+	query, group := strings.CutSuffix(query, "#group")
+	query, first := strings.CutSuffix(query, "#first")
+	query, last := strings.CutSuffix(query, "#last")
+	query, keys := strings.CutSuffix(query, "#keys")
+	query, presence := strings.CutSuffix(query, "?")
+	query = trimRoot(query)
+	if query == "$" || query == "." {
+		if keys {
+			return false // no key to record
+		}
+		n.Name = name // the root value itself
+		n.IsTerminal = true
+		n.First, n.Last = first, last
+		n.Presence = presence
+		n.Aggregate = aggregate || group // no array to group by
+		return true
+	}
 
-			if strings.Contains(segment, "[") {
-				child.AsArray = true
+	segments, recursive, ok := splitRecursive(query)
+	if !ok {
+		return false // empty query or empty segment, nothing to match
+	}
+	wild := false
+	for i, segment := range segments {
+		if recursive[i] {
+			_, segment, _ = splitDepth(segment)
+		}
+		key, _, _, _ := splitBracket(segment)
+		key = strings.TrimSuffix(key, "~")
+		_, isPattern, err := parsePattern(key)
+		if isPattern && err != nil {
+			return false // an invalid expression matches nothing
+		}
+		wild = wild || isPattern || strings.HasSuffix(key, "*")
+	}
+	if keys && !wild {
+		return false // no key to record
+	}
+	current := n
+	for i, segment := range segments {
+		final := i == len(segments)-1
+		body, depth := segment, 0
+		if recursive[i] {
+			depth, body, _ = splitDepth(segment)
+		}
+		key, index, rest, isArray := splitBracket(body)
+		match, rest, indexed := splitMatchIndex(index, rest)
+		neighbor, rest := splitNeighbor(index, rest)
+		step := segment[:len(segment)-len(rest)]
+		if recursive[i] {
+			step = ".." + step // kept apart from the same key without '..'
+		}
+		child := current.addSegment(step, final && rest == "")
+		child.Recursive = recursive[i]
+		child.DescentDepth = depth
+
+		if alternatives, ok := parseAlternatives(key); ok {
+			child.Alternatives = alternatives
+			key = string(alternatives[0])
+		}
+		key, child.Embedded = strings.CutSuffix(key, "~")
+		child.Pattern, _, _ = parsePattern(key)
+		key, child.AnyKey = strings.CutSuffix(key, "*")
+		key = unquoteKey(key) // a quoted key may contain dots, e.g. "metric.cpu."*
+		child.Key = []byte(key)
+		child.Nested = isArray && key == "" && !child.AnyKey // a leading [n] indexes the root array
+		child.Length = segment == "#"
+		if slices.Contains(metaSelectors, segment) {
+			child.Meta = segment[1:]
+		}
 
-				parts := strings.Split(segment, "[")
-				segment = parts[0]
-				child.Key = []byte(segment)
+		if occurrence, err := strconv.Atoi(index); err == nil && child.Recursive {
+			// ..id[2] is the third id found, not index 2 of an id array
+			child.MatchIndex, child.MatchIndexed = occurrence, true
+		} else if isArray {
+			child.setIndex(index)
+			child.MatchIndex, child.MatchIndexed = match, indexed
+			child.Neighbor = neighbor
+		}
 
-				index := strings.TrimSuffix(parts[1], "]")
+		// further brackets index into nested arrays, e.g. m[1][2]
+		for rest != "" {
+			step := rest
+			_, index, rest, _ = splitBracket(rest)
+			match, rest, indexed = splitMatchIndex(index, rest)
+			child = child.addSegment(step[:len(step)-len(rest)], final && rest == "")
+			child.Nested = true
+			child.setIndex(index)
+			child.MatchIndex, child.MatchIndexed = match, indexed
+		}
 
-				if index == "*" {
-					child.ArrayIndex = -1 // wildcard
-				} else if strings.HasPrefix(index, "?") {
-					filter_parts := strings.SplitN(index[1:], "=", 2)
-					if len(filter_parts) == 2 {
-						child.Filter = &PathFilter{
-							Key:   filter_parts[0],
-							Value: filter_parts[1],
-						}
-					}
-				} else {
-					var err error
-					if child.ArrayIndex, err = strconv.Atoi(index); err != nil {
-						child.ArrayIndex = -1 // treat as wildcard if parsing fails
-					}
-				}
+		current = child
+	}
+	current.Name = name
+	current.IsTerminal = true
+	current.First, current.Last = first, last
+	current.Presence = presence
+	current.Aggregate = aggregate
+	current.Group = group
+	current.Keys = keys
+	return true
+}
+
+// CompilePathsStrict is CompilePaths for untrusted queries: it rejects
+// unterminated brackets, empty segments and filters, filters with a doubled
+// operator, indices that are neither numbers, '*' nor a filter, repeated or
+// misordered suffixes and unbalanced (a|b) groups, instead of skipping or
+// guessing.
+func CompilePathsStrict(paths map[string]string) (*PathNode, error) {
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	slices.Sort(names) // report the same error every time
+	for _, name := range names {
+		if problem := checkPath(paths[name]); problem != "" {
+			return nil, &PathError{Name: name, Query: paths[name], Message: problem}
+		}
+	}
+	return CompilePaths(paths), nil
+}
+
+// CompileQuery compiles several named paths written as one string, like
+// "name: user.name; city: user.address.city". Entries are separated by ';'
+// and spaces around names and paths are ignored. A name given twice collects
+// the matches of both paths, as with CompilePathsMulti. Each path is checked
+// like CompilePathsStrict does, and an entry without a name is an error.
+func CompileQuery(query string) (*PathNode, error) {
+	paths := make(map[string][]string)
+	for _, entry := range splitOutside(query, ';') {
+		if strings.TrimSpace(entry) == "" {
+			continue // e.g. after a trailing ';'
+		}
+		name, path, ok := strings.Cut(entry, ":")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !ok || name == "" {
+			return nil, &PathError{Query: strings.TrimSpace(entry), Message: "expected name:path"}
+		}
+		if problem := checkPath(path); problem != "" {
+			return nil, &PathError{Name: name, Query: path, Message: problem}
+		}
+		paths[name] = append(paths[name], path)
+	}
+	return CompilePathsMulti(paths), nil
+}
+
+// addSegment returns the child compiled from segment, creating it if needed.
+// A terminal already claimed by another name is never reused as a terminal.
+func (n *PathNode) addSegment(segment string, terminal bool) *PathNode {
+	child, found := n.findSegment(segment)
+	if !found || terminal && child.IsTerminal {
+		child = &PathNode{Name: segment, Segment: segment}
+		child.Key = []byte(segment)
+		n.Children = append(n.Children, child)
+	}
+	return child
+}
+
+func (n *PathNode) setIndex(index string) {
+	n.AsArray = true
+
+	if i := strings.IndexByte(index, '?'); i >= 0 {
+		// a filter, optionally behind an index the element must also be at
+		n.Filter = parseFilter(index[i+1:])
+		index = index[:i]
+	}
+	if spec, ok := strings.CutPrefix(index, "*~"); ok {
+		n.SampleRate, n.SampleSize, _ = parseSample(spec)
+		index = "*"
+	}
+	if index == "*" || index == "" {
+		n.ArrayIndex = -1 // wildcard
+	} else {
+		var err error
+		if n.ArrayIndex, err = strconv.Atoi(index); err != nil {
+			n.ArrayIndex = -1 // treat as wildcard if parsing fails
+		} else if n.ArrayIndex < 0 {
+			n.ArrayIndex, n.FromEnd = 0, -n.ArrayIndex // resolved by ExtractArray
+		}
+	}
+}
+
+func (n *PathNode) findSegment(segment string) (*PathNode, bool) {
+	for _, child := range n.Children {
+		if child.Segment == segment {
+			return child, true
+		}
+	}
+	return nil, false
+}
+
+func (n *PathNode) markRepeated(repeated bool) {
+	n.InRepeated = repeated
+	n.Repeated = repeated || n.AnyKey || n.Pattern != nil || n.Recursive && !n.MatchIndexed || n.Meta == "keys" ||
+		n.AsArray && n.ArrayIndex == -1 && !n.MatchIndexed
+	for _, child := range n.Children {
+		child.markRepeated(n.Repeated)
+	}
+}
+
+// linkGroups lists every #group terminal in the Groups of the outermost array
+// step above it, outer, which stores the terminal's matches as one array per
+// element. Without such a step the terminal is an #array one.
+func (n *PathNode) linkGroups(outer *PathNode) {
+	for _, child := range n.Children {
+		if child.Group {
+			if outer != nil {
+				outer.Groups = append(outer.Groups, child)
+			} else {
+				child.Aggregate = true
 			}
+		}
+		if outer == nil && child.AsArray && !child.Recursive {
+			child.linkGroups(child)
+		} else {
+			child.linkGroups(outer)
+		}
+	}
+}
 
-			current = child
+// linkDescent gives every node with '..' children a Descent node holding
+// just those children. Descent is its own Descent, so matching continues at
+// every depth, and it takes all array elements. Its DescentDepth is the
+// deepest any of the children reach, 0 if one is unbounded.
+func (n *PathNode) linkDescent() {
+	unbounded := false
+	for _, child := range n.Children {
+		if child.Recursive {
+			if n.Descent == nil {
+				n.Descent = &PathNode{Segment: "..", ArrayIndex: -1, Repeated: true, InRepeated: true}
+				n.Descent.Descent = n.Descent
+			}
+			n.Descent.Children = append(n.Descent.Children, child)
+			n.Descent.DescentDepth = max(n.Descent.DescentDepth, child.DescentDepth)
+			unbounded = unbounded || child.DescentDepth == 0
 		}
-		current.Name = name
-		current.IsTerminal = true
-		terminals++
+		child.linkDescent()
 	}
-	root.NumTerminals = terminals
-	return root
+	if unbounded {
+		n.Descent.DescentDepth = 0
+	}
+}
+
+// reaches reports whether a Descent node still matches keys depth levels
+// below its parent.
+func (n *PathNode) reaches(depth int) bool {
+	return n.DescentDepth == 0 || depth <= n.DescentDepth
 }
 
 func NewPathResultWatcher(node *PathNode) *PathResultWatcher {
 	watcher := &PathResultWatcher{
-		Name: node.Name,
+		Name:     node.Name,
+		Terminal: node.IsTerminal,
 	}
-	watcher.Children = make(map[string]*PathResultWatcher)
+	watcher.Children = make(map[*PathNode]*PathResultWatcher)
 	for _, child := range node.Children {
-		watcher.Children[child.Name] = NewPathResultWatcher(child)
+		watcher.Children[child] = NewPathResultWatcher(child)
 	}
 	return watcher
 }
@@ -119,6 +556,9 @@ func (r *PathResultWatcher) AllComplete() bool {
 	if r.Complete {
 		return true
 	}
+	if r.Terminal {
+		return false // a terminal is only done once it has recorded its own result
+	}
 	for _, child := range r.Children {
 		if !child.AllComplete() {
 			return false
@@ -132,37 +572,248 @@ func NewExtractor(rawData []byte, root *PathNode) *Extractor {
 		RawData:       rawData,
 		Root:          root,
 		Results:       make(map[string][]string),
+		ResultsBytes:  make(map[string][][]byte),
+		Presence:      make(map[string]bool),
+		Paths:         make(map[string][]string),
+		Types:         make(map[string][]TokenType),
+		Depths:        make(map[string][]int),
+		Pairs:         make(map[string][]KeyValue),
+		Grouped:       make(map[string]RecordFields),
+		Members:       make(map[string][][]Member),
 		Scanner:       NewScanner(&rawData),
 		ResultWatcher: NewPathResultWatcher(root),
 	}
 }
 
+// NewExtractorRange extracts from rawData[start:end] without copying, for
+// JSON embedded in other framing; an end below zero means the end of rawData.
+// RawData is rawData up to end, so offsets and raw results index rawData.
+func NewExtractorRange(rawData []byte, start, end int, root *PathNode) *Extractor {
+	e := NewExtractor(rawData, root)
+	e.Scanner = NewScannerRange(&rawData, start, end)
+	e.RawData = *e.Scanner.data
+	return e
+}
+
 func (e *Extractor) Extract() error {
-	tok, _ := e.Scanner.Token()
-	switch tok {
-	case StartObject:
-		if err := e.ExtractObject(e.Root, e.ResultWatcher); err != nil {
-			return err
+	err := e.extractRoot()
+	if err == nil && e.ScanAll && e.ExtractionComplete {
+		e.Scanner.pos = e.rootStart
+		err = e.Scanner.validateValue() // the whole root value, not just the rest
+		e.scannedAll = true
+	}
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) && perr.Path == "" {
+			perr.Path = strings.Join(e.pathStack, "") // the path being read when it failed
 		}
-	case StartArray:
-		if err := e.ExtractArray(e.Root, e.ResultWatcher); err != nil {
-			return err
+		if e.Partial {
+			e.finishAggregates(e.Root)
+		}
+		return err
+	}
+	e.finishAggregates(e.Root)
+	e.applyDefaults()
+	if e.OnMissing != nil {
+		if missing := e.missing(); len(missing) > 0 {
+			e.OnMissing(missing)
 		}
-	default:
-		return fmt.Errorf("unexpected token %s at start of JSON", tok)
 	}
 	return nil
 }
 
+// missing returns the sorted result names that matched nothing, as reported
+// by Coverage.
+func (e *Extractor) missing() []string {
+	var names []string
+	for _, name := range e.Root.TerminalNames() {
+		if !e.matched[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CompletedEarly reports whether Extract stopped reading once every path was
+// satisfied, leaving the rest of the root value unscanned and unchecked.
+func (e *Extractor) CompletedEarly() bool {
+	return e.ExtractionComplete && !e.scannedAll
+}
+
+// finishAggregates stores the arrays built for #array paths, including an
+// empty [] for those that matched nothing.
+func (e *Extractor) finishAggregates(node *PathNode) {
+	if node.Aggregate {
+		value := append(e.aggregates[node.Name], ']')
+		if len(value) == 1 {
+			value = []byte("[]")
+		}
+		if e.ZeroCopy {
+			e.ResultsBytes[node.Name] = [][]byte{value}
+		} else {
+			e.Results[node.Name] = []string{string(value)}
+		}
+	}
+	for _, child := range node.Children {
+		e.finishAggregates(child)
+	}
+}
+
+// flushGroups stores the arrays built for #group paths from one element of
+// the array grouping them, [] where the element had no matches. A non-nil
+// identity is the element's GroupKey value.
+func (e *Extractor) flushGroups(groups []*PathNode, identity []byte) {
+	for _, node := range groups {
+		value := append(e.aggregates[node.Name], ']')
+		if len(value) == 1 {
+			value = []byte("[]")
+		}
+		delete(e.aggregates, node.Name)
+		if identity != nil {
+			e.addGroup(node.Name, string(identity), value)
+		}
+		if e.ZeroCopy {
+			e.ResultsBytes[node.Name] = append(e.ResultsBytes[node.Name], value)
+		} else {
+			e.Results[node.Name] = append(e.Results[node.Name], string(value))
+		}
+	}
+}
+
+// Coverage reports for every result name of the compiled paths whether it
+// matched at least once, ignoring Defaults and the empty [] of #array paths.
+// Extraction that stopped early reports only what was seen.
+func (e *Extractor) Coverage() map[string]bool {
+	names := e.Root.TerminalNames()
+	coverage := make(map[string]bool, len(names))
+	for _, name := range names {
+		coverage[name] = e.matched[name]
+	}
+	return coverage
+}
+
+// applyDefaults stores the default of every path that matched nothing.
+func (e *Extractor) applyDefaults() {
+	for name, value := range e.Defaults {
+		if e.resultCount(name) > 0 {
+			continue
+		}
+		if e.ZeroCopy {
+			e.ResultsBytes[name] = [][]byte{[]byte(value)}
+		} else {
+			e.Results[name] = []string{value}
+		}
+	}
+}
+
+// extractRoot matches the root value, which may also be a bare scalar like
+// 42 or "hello": only a root query captures it, and other paths find nothing.
+func (e *Extractor) extractRoot() error {
+	e.Scanner.plusSign = e.StrictNumbers
+	e.Scanner.skipWhitespace()
+	e.rootStart = e.Scanner.Pos()
+	if err := e.extractMatch(e.Root, e.ResultWatcher); err != nil {
+		return err
+	}
+	return e.Scanner.Err()
+}
+
+// Consumed returns the offset just past the root value. If extraction stopped
+// early the rest of the root value is skipped first, and a malformed rest is
+// reported by Scanner.Err.
+func (e *Extractor) Consumed() int {
+	if e.ExtractionComplete {
+		e.Scanner.pos = e.rootStart
+		e.Scanner.SkipValue()
+	}
+	return e.Scanner.Pos()
+}
+
 func (node *PathNode) FindChild(key []byte) *PathNode {
 	for _, child := range node.Children {
-		if bytes.Equal(child.Key, key) {
+		if child.matchesKey(key) {
 			return child
 		}
 	}
 	return nil
 }
 
+func (n *PathNode) matchesKey(key []byte) bool {
+	if n.Nested || n.Length || n.Meta != "" {
+		return false // steps into arrays or meta-selectors, never object keys
+	}
+	if n.Pattern != nil {
+		return n.Pattern.Match(key)
+	}
+	if n.AnyKey {
+		return bytes.HasPrefix(key, n.Key)
+	}
+	if n.Alternatives == nil {
+		return bytes.Equal(n.Key, key)
+	}
+	for _, alternative := range n.Alternatives {
+		if bytes.Equal(alternative, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Extractor) matchKey(node *PathNode, key []byte) bool {
+	if !e.NormalizeKeys && e.KeyMatcher == nil || node.Nested || node.Length || node.Meta != "" {
+		return node.matchesKey(key)
+	}
+	normalize := func(b []byte) []byte { return b }
+	if e.NormalizeKeys {
+		normalize = nfc
+	}
+	key = normalize(key)
+	if node.Pattern != nil {
+		return node.Pattern.Match(key) // KeyMatcher does not apply
+	}
+	if node.AnyKey {
+		return bytes.HasPrefix(key, normalize(node.Key))
+	}
+	var matcher KeyMatcher = ExactMatcher{}
+	if e.KeyMatcher != nil {
+		matcher = e.KeyMatcher
+	}
+	if node.Alternatives == nil {
+		return matcher.Match(normalize(node.Key), key)
+	}
+	for _, alternative := range node.Alternatives {
+		if matcher.Match(normalize(alternative), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func nfc(b []byte) []byte {
+	if norm.NFC.IsNormal(b) {
+		return b
+	}
+	return norm.NFC.Bytes(b)
+}
+
+// TerminalNames returns the sorted result names the tree below n produces,
+// each once even when several queries share a name.
+func (n *PathNode) TerminalNames() []string {
+	var names []string
+	n.collectNames(&names)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func (n *PathNode) collectNames(names *[]string) {
+	if n.IsTerminal {
+		*names = append(*names, n.Name)
+	}
+	for _, child := range n.Children {
+		child.collectNames(names)
+	}
+}
+
 func (p *PathNode) FindChildByName(name string) (*PathNode, bool) {
 	for _, child := range p.Children {
 		if child.Name == name {
@@ -172,7 +823,15 @@ func (p *PathNode) FindChildByName(name string) (*PathNode, bool) {
 	return nil, false
 }
 
+// AllResultsReturned reports whether extraction can stop: every path is
+// complete, or MaxMatchedPaths names have matched.
 func (e *Extractor) AllResultsReturned() bool {
+	if e.MaxMatchedPaths > 0 && len(e.matched) >= e.MaxMatchedPaths {
+		return true
+	}
+	if e.ResultWatcher.Terminal && !e.ResultWatcher.Complete {
+		return false
+	}
 	for _, r := range e.ResultWatcher.Children {
 		if !r.AllComplete() {
 			return false
@@ -182,92 +841,1055 @@ func (e *Extractor) AllResultsReturned() bool {
 }
 
 func (e *Extractor) ExtractObject(node *PathNode, resultNode *PathResultWatcher) error {
+	defer e.Scanner.leave()
+	if err := e.Scanner.enter(); err != nil {
+		return err
+	}
+	var taken []*PathNode // alternation groups already matched in this object
 	for e.Scanner.More() {
 		key, err := e.Scanner.ExpectString()
 		if err != nil {
 			return err
 		}
+		keyStart := e.Scanner.Pos() - len(key)
+		if e.RawData[e.Scanner.Pos()-1] == '"' {
+			keyStart -= 2 // quotes, absent from Lenient's unquoted keys
+		}
+		key = unescaped(key) // so "a\/b" matches the query key a/b
+		if e.Scanner.peek() == ':' {
+			e.Scanner.pos++ // skip colon
+		}
+		if err := e.Scanner.expectValue(); err != nil {
+			return err
+		}
 
-		childNode := node.FindChild(key)
-		if childNode == nil {
+		if e.IgnoreKeys[string(key)] {
+			e.trace(keyStart, "key %q ignored", key)
 			e.Scanner.SkipValue()
 			continue
 		}
 
-		tok, val := e.Scanner.Token()
-		switch tok {
-		case StartObject:
-			if err := e.ExtractObject(childNode, resultNode.Children[childNode.Name]); err != nil {
+		start := e.Scanner.Pos()
+		matched := false
+		for _, childNode := range node.Children {
+			if !e.matchKey(childNode, key) || childNode.Recursive && !childNode.reaches(e.descended+1) {
+				continue
+			}
+			if e.ScalarWildcards && childNode.AnyKey && childNode.IsTerminal && e.containerAt(start) {
+				continue // only scalars are collected
+			}
+			if childNode.Alternatives != nil {
+				if slices.Contains(taken, childNode) {
+					continue
+				}
+				taken = append(taken, childNode)
+			}
+			if matched {
+				e.Scanner.pos = start // rewind so every path on this key sees the value
+			}
+			matched = true
+			e.trace(keyStart, "key %q matched %s", key, childNode.Segment)
+
+			e.pushKey(key)
+			wildKey, descended := e.wildKey, e.descended
+			if childNode.AnyKey || childNode.Pattern != nil {
+				e.wildKey = key
+			}
+			e.descended = 0 // '..' below the child counts from the child
+			if err := e.extractValue(childNode, resultNode.Children[childNode]); err != nil {
 				return err
 			}
-		case StartArray:
-			if err := e.ExtractArray(childNode, resultNode.Children[childNode.Name]); err != nil {
+			e.wildKey, e.descended = wildKey, descended
+			e.popPath()
+
+			if e.ExtractionComplete {
+				return nil
+			}
+		}
+		if node.Descent != nil && node.Descent.reaches(e.descended+2) {
+			e.pushKey(key)
+			e.descended++
+			err := e.descend(node, resultNode, start)
+			e.descended--
+			e.popPath()
+			if err != nil || e.ExtractionComplete {
 				return err
 			}
-		default:
-			if childNode.IsTerminal {
-				e.AddResult(childNode, resultNode.Children[childNode.Name], false, string(val))
-			} else {
-				e.Scanner.SkipValue() // skip value for non-object/array tokens
+		} else if !matched {
+			e.trace(keyStart, "key %q skipped", key)
+			e.Scanner.SkipValue()
+		}
+	}
+	if err := e.Scanner.ExpectEndObject(); err != nil {
+		return err
+	}
+	e.endObject(node, resultNode)
+	return nil
+}
+
+// containerAt reports whether the value at pos is an object or array.
+func (e *Extractor) containerAt(pos int) bool {
+	saved := e.Scanner.pos
+	e.Scanner.pos = pos
+	c := e.Scanner.peek()
+	e.Scanner.pos = saved
+	return c == '{' || c == '['
+}
+
+// extractValue matches the value stored under node's key. Array nodes only
+// match array values; their elements are matched by ExtractArray. The
+// exception is a plain filter over an object or scalar, as in
+// user[?verified=true].email or flags.*[?@=true], which tests the value
+// itself; an object under flags.*[?@=true] is compared as a whole, so it never
+// matches and nothing inside it is searched.
+func (e *Extractor) extractValue(node *PathNode, resultNode *PathResultWatcher) error {
+	if node.Recursive && node.MatchIndexed {
+		if e.occurrences == nil {
+			e.occurrences = make(map[*PathNode]int)
+		}
+		n := e.occurrences[node]
+		e.occurrences[node]++
+		if n != node.MatchIndex {
+			e.Scanner.SkipValue() // not the requested occurrence
+			return e.Scanner.Err()
+		}
+	}
+	if node.Embedded && e.Scanner.peek() == '"' {
+		return e.extractEmbedded(node, resultNode)
+	}
+	return e.matchValue(node, resultNode)
+}
+
+// extractEmbedded matches node against the JSON text held by the string value
+// being read, as for payload~.id with {"payload":"{\"id\":5}"}. The string
+// is unescaped once; a further encoding needs a further '~', as in
+// payload~.inner~.id. A string that is not JSON is rejected like a malformed
+// value, see CollectErrors. Values other than strings are matched as they
+// are.
+func (e *Extractor) extractEmbedded(node *PathNode, resultNode *PathResultWatcher) error {
+	outer := e.Scanner
+	start := outer.Pos()
+	_, raw := outer.Token()
+	if err := outer.Err(); err != nil {
+		return err
+	}
+	doc, err := Unescape(raw)
+	if err == nil {
+		err = Validate(doc)
+	}
+	if err != nil {
+		msg := err.Error()
+		if pe, ok := err.(*ParseError); ok {
+			msg = pe.Message // its offset is into the decoded string
+		}
+		return e.rejectValue(start, "string under %s is not JSON: %s", node.Segment, msg)
+	}
+
+	inner := NewScanner(&doc)
+	inner.MaxDepth = outer.MaxDepth
+	inner.depth = outer.depth // the nesting goes on inside the string
+	data := e.RawData
+	e.Scanner, e.RawData = inner, doc
+	err = e.matchValue(node, resultNode)
+	e.Scanner, e.RawData = outer, data
+	return err
+}
+
+// matchValue matches the value under node's key once any '..' occurrence
+// and '~' decoding are resolved.
+func (e *Extractor) matchValue(node *PathNode, resultNode *PathResultWatcher) error {
+	if !node.AsArray {
+		return e.extractMatch(node, resultNode)
+	}
+	c := e.Scanner.peek()
+	if c != '[' && node.Filter != nil && node.ArrayIndex == -1 && !node.MatchIndexed && node.Neighbor == 0 {
+		// the filter reads the whole value first, so the fields it tests
+		// may come before or after the ones extracted
+		start := e.Scanner.Pos()
+		matched := e.matchesFilter(node.Filter, start)
+		e.Scanner.pos = start
+		if matched {
+			return e.extractMatch(node, resultNode)
+		}
+	}
+	if c != '[' {
+		e.Scanner.SkipValue()
+		return e.Scanner.Err()
+	}
+	e.Scanner.pos++ // skip opening bracket
+	return e.ExtractArray(node, resultNode)
+}
+
+// extractMatch reads a value selected by node. Terminal containers are
+// recorded as raw JSON after any child paths inside them are extracted.
+// Either way the scanner ends just past the value, so the caller carries on
+// with the next key or element as after a skipped one.
+func (e *Extractor) extractMatch(node *PathNode, resultNode *PathResultWatcher) error {
+	e.Scanner.skipWhitespace()
+	start := e.Scanner.Pos()
+	if n := node.metaChildren(); n > 0 {
+		if err := e.extractMeta(node, resultNode, start); err != nil || e.ExtractionComplete {
+			return err
+		}
+		if n == len(node.Children) && !node.IsTerminal {
+			e.Scanner.SkipValue() // nothing else to find inside
+			return e.Scanner.Err()
+		}
+	}
+	tok, val := e.Scanner.Token()
+	switch tok {
+	case StartObject, StartArray:
+		if node.IsTerminal && len(node.Children) == 0 {
+			e.Scanner.pos = start
+			e.Scanner.SkipValue()
+			if err := e.Scanner.Err(); err != nil {
+				return err
 			}
+			return e.AddResult(node, resultNode, tok, e.RawData[start:e.Scanner.Pos()])
+		}
+
+		var err error
+		if tok == StartObject {
+			err = e.ExtractObject(node, resultNode)
+		} else if node.hasNested() {
+			err = e.extractNested(node, resultNode, start)
+		} else {
+			err = e.ExtractArray(node, resultNode)
+		}
+		if err != nil || !node.IsTerminal || e.ExtractionComplete {
+			return err
 		}
+		return e.AddResult(node, resultNode, tok, e.RawData[start:e.Scanner.Pos()])
+	case NoToken:
+		return e.Scanner.fail(e.Scanner.Pos(), "unexpected end of input")
+	case EndObject, EndArray:
+		return e.Scanner.failToken(start, tok, "unexpected %s", tok)
+	default:
+		if node.IsTerminal {
+			return e.AddResult(node, resultNode, tok, val)
+		}
+	}
+	return nil
+}
 
+// hasNested reports whether node has children that apply to an array as a
+// whole: nested index steps and '#' lengths.
+func (n *PathNode) hasNested() bool {
+	for _, child := range n.Children {
+		if child.Nested || child.Length {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *PathNode) metaChildren() int {
+	count := 0
+	for _, child := range n.Children {
+		if child.Meta != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// extractMeta resolves the meta-selector children of node against the value
+// at start, rewinding to it afterwards. @length counts the members or
+// elements of an object or array and @keys lists the keys of an object, one
+// result each; on other values they fail like a malformed value would, see
+// CollectErrors. Keys are stored like string values, so Verbatim keeps their
+// quotes and escapes for round-tripping. @type names any value's type as a
+// @type filter does.
+func (e *Extractor) extractMeta(node *PathNode, resultNode *PathResultWatcher, start int) error {
+	s := e.Scanner
+	for _, child := range node.Children {
+		if child.Meta == "" {
+			continue
+		}
+		s.pos = start
+		tok, _ := s.Token()
+		if child.Meta == "type" {
+			s.pos = start
+			s.SkipValue()
+			if err := e.AddResult(child, resultNode.Children[child], String, []byte(jsonType(tok))); err != nil {
+				return err
+			}
+			continue
+		}
+		if tok != StartObject && (child.Meta == "keys" || tok != StartArray) {
+			s.pos = start
+			s.SkipValue()
+			if err := e.rejectValue(start, "@%s of %s for %s", child.Meta, jsonType(tok), child.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		n := 0
+		for s.More() {
+			if tok == StartObject {
+				key, err := s.ExpectString()
+				if err != nil {
+					return err
+				}
+				if child.Meta == "keys" {
+					if err := e.AddResult(child, resultNode.Children[child], String, key); err != nil {
+						return err
+					}
+				}
+			}
+			s.SkipValue()
+			n++
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+		if child.Meta == "length" {
+			if err := e.AddResult(child, resultNode.Children[child], Number, []byte(strconv.Itoa(n))); err != nil {
+				return err
+			}
+		}
 		if e.ExtractionComplete {
 			return nil
 		}
 	}
-	if err := e.Scanner.ExpectEndObject(); err != nil {
+	s.pos = start
+	return nil
+}
+
+// extractNested matches the array starting at start against each nested
+// index step and length of node, rewinding between them. Keys below node are
+// then matched against the elements as usual.
+func (e *Extractor) extractNested(node *PathNode, resultNode *PathResultWatcher, start int) error {
+	keyed := false
+	for _, child := range node.Children {
+		if !child.Nested && !child.Length {
+			keyed = true
+			continue
+		}
+		e.Scanner.pos = start
+		e.Scanner.Token() // opening bracket
+		var err error
+		if child.Length {
+			err = e.extractLength(child, resultNode.Children[child])
+		} else {
+			err = e.ExtractArray(child, resultNode.Children[child])
+		}
+		if err != nil || e.ExtractionComplete {
+			return err
+		}
+	}
+	if keyed {
+		e.Scanner.pos = start
+		e.Scanner.Token() // opening bracket
+		return e.ExtractArray(node, resultNode)
+	}
+	return nil
+}
+
+// extractLength records the number of elements of the array being read.
+func (e *Extractor) extractLength(node *PathNode, resultNode *PathResultWatcher) error {
+	n := 0
+	for e.Scanner.More() {
+		if err := e.Scanner.nextElement(n); err != nil {
+			return err
+		}
+		e.Scanner.SkipValue()
+		n++
+	}
+	if err := e.Scanner.ExpectEndArray(); err != nil {
 		return err
 	}
+	return e.AddResult(node, resultNode, Number, []byte(strconv.Itoa(n)))
+}
 
+// rejectValue reports a value that cannot be stored. With CollectErrors the
+// error is recorded and the value dropped; otherwise extraction stops.
+func (e *Extractor) rejectValue(offset int, format string, args ...any) error {
+	if !e.CollectErrors {
+		return e.Scanner.fail(offset, format, args...)
+	}
+	err := newParseError(*e.Scanner.data, offset, NoToken, fmt.Sprintf(format, args...))
+	err.Path = strings.Join(e.pathStack, "")
+	e.errs = append(e.errs, err)
 	return nil
 }
 
-func (e *Extractor) AddResult(node *PathNode, resultNode *PathResultWatcher, wildcardEnd bool, value string) {
-	e.Results[node.Name] = append(e.Results[node.Name], value)
-	if node.AsArray {
-		if wildcardEnd {
-			resultNode.Complete = true
+// Errors returns the values rejected while CollectErrors was set, in
+// document order.
+func (e *Extractor) Errors() []error {
+	return e.errs
+}
+
+func (e *Extractor) resultLimit(node *PathNode) int {
+	if node.First {
+		return 1
+	}
+	if node.Last {
+		return 0 // each value replaces the one before, so a cap would keep the first
+	}
+	if limit, ok := e.ResultLimits[node.Name]; ok {
+		return limit
+	}
+	return e.MaxResults
+}
+
+// reserve preallocates the result slice of name from the size hints.
+func (e *Extractor) reserve(name string) {
+	hint, ok := e.SizeHints[name]
+	if !ok {
+		hint = e.SizeHint
+	}
+	if hint <= 0 || e.CountValues {
+		return
+	}
+	if e.ZeroCopy {
+		if e.ResultsBytes[name] == nil {
+			e.ResultsBytes[name] = make([][]byte, 0, hint)
+		}
+	} else if e.Results[name] == nil {
+		e.Results[name] = make([]string, 0, hint)
+	}
+}
+
+func (e *Extractor) resultCount(name string) int {
+	if e.CountValues {
+		if c := e.counts[name]; c != nil {
+			return c.total
+		}
+		return 0
+	}
+	if e.ZeroCopy {
+		return len(e.ResultsBytes[name])
+	}
+	return len(e.Results[name])
+}
+
+func (e *Extractor) clearResults(name string) {
+	if e.CountValues {
+		delete(e.counts, name)
+	} else if e.ZeroCopy {
+		e.ResultsBytes[name] = e.ResultsBytes[name][:0]
+	} else {
+		e.Results[name] = e.Results[name][:0]
+	}
+	if e.RecordPaths {
+		e.Paths[name] = e.Paths[name][:0]
+	}
+	if e.RecordTypes {
+		e.Types[name] = e.Types[name][:0]
+	}
+	if e.RecordDepths {
+		e.Depths[name] = e.Depths[name][:0]
+	}
+	if e.CapturePairs {
+		e.Pairs[name] = e.Pairs[name][:0]
+	}
+	if e.RecordMembers {
+		e.Members[name] = e.Members[name][:0]
+	}
+}
+
+// AddResult records a value for node. A path that reaches its result limit is
+// treated as complete, so extraction can finish once every path is capped.
+func (e *Extractor) AddResult(node *PathNode, resultNode *PathResultWatcher, tok TokenType, value []byte) error {
+	if e.matched == nil {
+		e.matched = make(map[string]bool)
+	}
+	e.matched[node.Name] = true
+	if node.Presence {
+		e.Presence[node.Name] = true
+		resultNode.Complete = true
+		if e.AllResultsReturned() {
+			e.ExtractionComplete = true
+		}
+		return nil
+	}
+
+	if node.Aggregate || node.Group {
+		e.aggregate(node, tok, value)
+		return nil
+	}
+
+	limit := e.resultLimit(node)
+	if limit > 0 && e.resultCount(node.Name) >= limit {
+		return nil
+	}
+	if tok == Null {
+		value = []byte("null") // unlike "", which is an empty string
+	}
+	offset := e.Scanner.Pos() - len(value)
+	if tok == String {
+		offset -= 2 // quotes
+	}
+	if tok == String && e.UnescapeStrings {
+		var err error
+		if value, err = Unescape(value); err != nil {
+			return e.rejectValue(offset, "%s in string for %s", err, node.Name)
+		}
+	}
+	if node.Keys {
+		tok, value = String, e.wildKey // already unescaped
+	}
+	if tok == Number && e.StrictNumbers {
+		if value = bytes.TrimPrefix(value, []byte("+")); !validNumber(value) {
+			return e.rejectValue(offset, "invalid number %q for %s", value, node.Name)
+		}
+	}
+	if tok == Number && e.NormalizeNumbers {
+		f, err := strconv.ParseFloat(string(value), 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return e.rejectValue(offset, "invalid number %q for %s", value, node.Name)
+		}
+		if !math.IsInf(f, 0) && !math.IsNaN(f) { // Lenient's NaN and Infinity stay as written
+			value = strconv.AppendFloat(nil, f, 'g', -1, 64)
+		}
+	}
+	if e.Verbatim && !node.Length && !node.Keys && (node.Meta == "" || node.Meta == "keys") {
+		value = (*e.Scanner.data)[offset:e.Scanner.Pos()]
+	}
+	if transform, ok := e.Transforms[node.Name]; ok {
+		var err error
+		if value, err = transform(value); err != nil {
+			err = fmt.Errorf("transform for %s: %w", node.Name, err)
+			if e.CollectErrors {
+				e.errs = append(e.errs, err)
+				return nil
+			}
+			return err
+		}
+	}
+	if node.Last && e.resultCount(node.Name) > 0 {
+		e.clearResults(node.Name)
+	}
+	if e.resultCount(node.Name) == 0 {
+		e.reserve(node.Name)
+	}
+	if e.CountValues {
+		e.countValue(node.Name, value)
+	} else if e.ZeroCopy {
+		if e.Arena != nil {
+			value = e.Arena.copy(value)
 		}
+		e.ResultsBytes[node.Name] = append(e.ResultsBytes[node.Name], value)
 	} else {
+		e.Results[node.Name] = append(e.Results[node.Name], string(value))
+	}
+	if e.RecordPaths {
+		e.Paths[node.Name] = append(e.Paths[node.Name], strings.Join(e.pathStack, ""))
+	}
+	if e.RecordTypes {
+		e.Types[node.Name] = append(e.Types[node.Name], tok)
+	}
+	if e.RecordDepths {
+		e.Depths[node.Name] = append(e.Depths[node.Name], e.Scanner.depth)
+	}
+	if e.RecordMembers {
+		var members []Member
+		if tok == StartObject {
+			members = objectMembers(value)
+		}
+		e.Members[node.Name] = append(e.Members[node.Name], members)
+	}
+	if e.CapturePairs && e.wildKey != nil {
+		e.Pairs[node.Name] = append(e.Pairs[node.Name], KeyValue{Key: string(e.wildKey), Value: string(value)})
+	}
+	e.trace(offset, "%s %q stored for %s", tok, value, node.Name)
+	if e.OnResult != nil && !e.OnResult(node.Name, value) {
+		e.ExtractionComplete = true
+		return nil
+	}
+	if limit > 0 && e.resultCount(node.Name) >= limit {
 		resultNode.Complete = true
+	} else if !node.Repeated {
+		e.completeMember(node, resultNode)
 	}
 	if e.AllResultsReturned() {
 		e.ExtractionComplete = true
 	}
+	return nil
+}
+
+// addGroup stores a #group array under the GroupKey value of its element,
+// joining it to the array of an earlier element with the same value.
+func (e *Extractor) addGroup(name, identity string, value []byte) {
+	record := e.Grouped[identity]
+	if record == nil {
+		record = make(RecordFields)
+		e.Grouped[identity] = record
+	}
+	earlier, ok := record[name]
+	if ok && string(value) == "[]" {
+		return
+	}
+	if ok && earlier != "[]" {
+		value = append([]byte(earlier[:len(earlier)-1]+","), value[1:]...)
+	}
+	record[name] = string(value)
+}
+
+// identity returns the GroupKey value of the element starting at start, or
+// nil when it has none, leaving the scanner where it was.
+func (e *Extractor) identity(start int) []byte {
+	s := e.Scanner
+	saved := s.pos
+	defer func() { s.pos = saved }()
+	s.pos = start
+	if tok, _ := s.Token(); tok != StartObject {
+		return nil
+	}
+	for s.More() {
+		key, err := s.ExpectString()
+		if err != nil {
+			return nil
+		}
+		if string(unescaped(key)) != e.GroupKey {
+			s.SkipValue()
+			continue
+		}
+		switch tok, val := s.Token(); tok {
+		case String:
+			return unescaped(val)
+		case Number, Boolean:
+			return val
+		}
+		return nil
+	}
+	return nil
+}
+
+// aggregate appends the raw JSON of a match to the array built for a #array
+// path. Strings keep their quotes and null is written out.
+func (e *Extractor) aggregate(node *PathNode, tok TokenType, value []byte) {
+	switch tok {
+	case String:
+		value = e.RawData[e.Scanner.Pos()-len(value)-2 : e.Scanner.Pos()]
+	case Null:
+		value = []byte("null")
+	}
+	if e.aggregates == nil {
+		e.aggregates = make(map[string][]byte)
+	}
+	buf := e.aggregates[node.Name]
+	if buf == nil {
+		buf = []byte{'['}
+	} else {
+		buf = append(buf, ',')
+	}
+	e.aggregates[node.Name] = append(buf, value...)
+}
+
+// matchesFilter reports whether the element starting at start satisfies the
+// filter: either the element itself or, for a keyed filter, the value under
+// the key of an object element. The scanner position is not restored.
+func (e *Extractor) matchesFilter(filter *PathFilter, start int) bool {
+	s := e.Scanner
+	s.pos = start
+	if filter.Type {
+		tok, _ := s.Token()
+		return (jsonType(tok) == filter.Value) == (filter.Op == "=")
+	}
+	if filter.Key == "" && filter.Subset {
+		return filter.contains(*s.data, start)
+	}
+	tok, val := s.Token()
+	if filter.Key == "" {
+		return filter.compare(tok, val)
+	}
+	if tok != StartObject {
+		return false
+	}
+	if filter.Ref != "" {
+		return e.matchesRef(filter)
+	}
+	for s.More() {
+		key, err := s.ExpectString()
+		if err != nil {
+			return false
+		}
+		if string(unescaped(key)) != filter.Key {
+			s.SkipValue()
+			continue
+		}
+		if filter.Subset {
+			return filter.contains(*s.data, s.pos)
+		}
+		return filter.compare(s.Token())
+	}
+	return false
+}
+
+// matchesRef compares two fields of the object being read, as in
+// [?start=@end]. A missing field or one holding an object or array fails the
+// filter, whatever the operator. Fields of different types are never equal.
+func (e *Extractor) matchesRef(filter *PathFilter) bool {
+	s := e.Scanner
+	var leftTok, rightTok TokenType // NoToken until the field is seen
+	var left, right []byte
+	for s.More() {
+		key, err := s.ExpectString()
+		if err != nil {
+			return false
+		}
+		key = unescaped(key)
+		if string(key) != filter.Key && string(key) != filter.Ref {
+			s.SkipValue()
+			continue
+		}
+		tok, val := s.Token()
+		if tok == StartObject || tok == StartArray {
+			return false
+		}
+		if string(key) == filter.Key {
+			leftTok, left = tok, val
+		}
+		if string(key) == filter.Ref {
+			rightTok, right = tok, val
+		}
+	}
+	if leftTok == NoToken || rightTok == NoToken {
+		return false
+	}
+	if leftTok != rightTok {
+		return filter.Op == "!="
+	}
+	switch rightTok {
+	case Null:
+		right = []byte("null")
+	case String:
+		if unescaped, err := Unescape(right); err == nil {
+			right = unescaped
+		}
+	}
+	resolved := PathFilter{Key: filter.Key, Op: filter.Op, Value: string(right)}
+	return resolved.compare(leftTok, left)
+}
+
+// contains applies a Subset filter to the value at pos.
+func (f *PathFilter) contains(data []byte, pos int) bool {
+	return containsJSON(data, pos, []byte(f.Value)) == (f.Op == "=")
+}
+
+// compare applies the filter operator to a scalar token. The ordering
+// operators only hold for numbers.
+func (f *PathFilter) compare(tok TokenType, val []byte) bool {
+	switch tok {
+	case StartObject, StartArray, NoToken:
+		return false
+	case Null:
+		val = []byte("null")
+	case String:
+		if unescaped, err := Unescape(val); err == nil {
+			val = unescaped // the filter value was unquoted too
+		}
+	}
+
+	switch f.Op {
+	case "=":
+		return string(val) == f.Value || tok == Number && NumbersEqual(val, []byte(f.Value))
+	case "!=":
+		return string(val) != f.Value && !(tok == Number && NumbersEqual(val, []byte(f.Value)))
+	}
+
+	if tok != Number {
+		return false
+	}
+	x, err := strconv.ParseFloat(string(val), 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseFloat(f.Value, 64)
+	if err != nil {
+		return false
+	}
+	switch f.Op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+func (e *Extractor) pushKey(key []byte) {
+	if !e.RecordPaths {
+		return
+	}
+	if len(e.pathStack) == 0 {
+		e.pathStack = append(e.pathStack, string(key))
+	} else {
+		e.pathStack = append(e.pathStack, "."+string(key))
+	}
+}
+
+func (e *Extractor) pushIndex(idx int) {
+	if e.RecordPaths {
+		e.pathStack = append(e.pathStack, "["+strconv.Itoa(idx)+"]")
+	}
+}
+
+func (e *Extractor) popPath() {
+	if e.RecordPaths {
+		e.pathStack = e.pathStack[:len(e.pathStack)-1]
+	}
 }
 
 func (e *Extractor) EndArray(node *PathNode, resultNode *PathResultWatcher) {
-	resultNode.Complete = true
+	if node.InRepeated || node.AnyKey {
+		return // the array may occur again under another match
+	}
+	if node.IsTerminal && !node.AsArray {
+		return // completed once the whole array is recorded
+	}
+	e.completeMember(node, resultNode)
 	if e.AllResultsReturned() {
 		e.ExtractionComplete = true
 	}
 }
 
+// completeMember marks a single-valued node as done with. A node reached by an
+// object key only completes at the end of that object, in endObject, so every
+// value of a key given twice is collected, in document order.
+func (e *Extractor) completeMember(node *PathNode, resultNode *PathResultWatcher) {
+	if node == e.Root || node.Meta != "" {
+		resultNode.Complete = true
+	} else {
+		resultNode.matched = true
+	}
+}
+
+// endObject completes the children of node that matched in the object just
+// read; see completeMember.
+func (e *Extractor) endObject(node *PathNode, resultNode *PathResultWatcher) {
+	completed := false
+	for _, child := range node.Children {
+		if r := resultNode.Children[child]; r != nil && r.matched && !r.Complete {
+			r.Complete, completed = true, true
+		}
+	}
+	if completed && e.AllResultsReturned() {
+		e.ExtractionComplete = true
+	}
+}
+
+// descend rewinds to the value at start and matches the '..' children of
+// node against everything nested inside it.
+func (e *Extractor) descend(node *PathNode, resultNode *PathResultWatcher, start int) error {
+	e.Scanner.pos = start
+	return e.extractInto(node.Descent, resultNode)
+}
+
+// extractInto matches the children of node against an element of an array
+// found where node expected a single value. The element itself is never a
+// result for node; a terminal node records the whole array instead.
+func (e *Extractor) extractInto(node *PathNode, resultNode *PathResultWatcher) error {
+	switch e.Scanner.peek() {
+	case '{':
+		e.Scanner.Token()
+		return e.ExtractObject(node, resultNode)
+	case '[':
+		e.Scanner.Token()
+		return e.ExtractArray(node, resultNode)
+	}
+	e.Scanner.SkipValue()
+	return e.Scanner.Err()
+}
+
+// trace writes a line about the input at offset to Trace, if set.
+func (e *Extractor) trace(offset int, format string, args ...any) {
+	if e.Trace == nil {
+		return
+	}
+	line, column := lineColumn(e.RawData, min(offset, len(e.RawData)))
+	fmt.Fprintf(e.Trace, "%d:%d %s\n", line, column, fmt.Sprintf(format, args...))
+}
+
+// countMatches counts the elements of the array being read that match
+// filter, leaving the scanner where it was.
+func (e *Extractor) countMatches(filter *PathFilter) int {
+	s := e.Scanner
+	start := s.pos
+	n := 0
+	for i := 0; s.More() && s.nextElement(i) == nil; i++ {
+		elem := s.Pos()
+		s.SkipValue()
+		end := s.Pos()
+		if filter == nil || e.matchesFilter(filter, elem) {
+			n++
+		}
+		s.pos = end
+	}
+	s.pos = start
+	return n
+}
+
+// neighborIndices returns the indices of the elements node.Neighbor away
+// from the filter matches of the array being read, or from the one match
+// selected by MatchIndex, leaving the scanner where it was. Offsets past
+// either end of the array select nothing.
+func (e *Extractor) neighborIndices(node *PathNode) map[int]bool {
+	s := e.Scanner
+	start := s.pos
+	var matches []int
+	n := 0
+	for ; s.More() && s.nextElement(n) == nil; n++ {
+		elem := s.Pos()
+		s.SkipValue()
+		end := s.Pos()
+		if e.matchesFilter(node.Filter, elem) {
+			matches = append(matches, n)
+		}
+		s.pos = end
+	}
+	s.pos = start
+
+	if node.MatchIndexed {
+		i := node.MatchIndex
+		if i < 0 {
+			i += len(matches)
+		}
+		if i < 0 || i >= len(matches) {
+			return nil
+		}
+		matches = matches[i : i+1]
+	}
+	indices := make(map[int]bool, len(matches))
+	for _, m := range matches {
+		if target := m + node.Neighbor; target >= 0 && target < n {
+			indices[target] = true
+		}
+	}
+	return indices
+}
+
+// keepSample decides whether an element survives sampling: with probability
+// rate, or for a fixed size by selection sampling, which keeps exactly want of
+// the left remaining elements.
+func (e *Extractor) keepSample(rate float64, want, left int) bool {
+	var r float64
+	if e.Rand != nil {
+		r = e.Rand.Float64()
+	} else {
+		r = rand.Float64()
+	}
+	if rate > 0 {
+		return r < rate
+	}
+	return float64(left)*r < float64(want)
+}
+
+// ExtractArray reads the elements of an array whose opening bracket has been
+// consumed. Elements selected by node's index and filter are matched against
+// node, so a terminal like orders[?status=active] records each matching
+// element as raw JSON while the others are skipped.
 func (e *Extractor) ExtractArray(node *PathNode, resultNode *PathResultWatcher) error {
+	defer e.Scanner.leave()
+	if err := e.Scanner.enter(); err != nil {
+		return err
+	}
 	idx := 0
+	index := node.ArrayIndex
+	if node.FromEnd > 0 {
+		n := e.countMatches(nil)
+		if index = n - node.FromEnd; index < 0 {
+			index = n // shorter than the index reaches back, so no element
+		}
+	}
+	matches, want := 0, node.MatchIndex
+	if node.Filter != nil && node.MatchIndexed && want < 0 {
+		want += e.countMatches(node.Filter)
+	}
+	sampleLeft, elemsLeft := node.SampleSize, 0
+	if node.SampleSize > 0 {
+		elemsLeft = e.countMatches(nil)
+	}
+	var neighbors map[int]bool
+	if node.Neighbor != 0 {
+		neighbors = e.neighborIndices(node)
+	}
 	for e.Scanner.More() {
-		if node.Filter == nil && node.ArrayIndex != -1 && node.ArrayIndex != idx {
+		if err := e.Scanner.nextElement(idx); err != nil {
+			return err
+		}
+		start := e.Scanner.Pos()
+		if index != -1 && index != idx {
+			if !node.AsArray && node.Descent != nil {
+				// the array is node's value, so '..' reaches every element
+				e.pushIndex(idx)
+				err := e.descend(node, resultNode, start)
+				e.popPath()
+				if err != nil || e.ExtractionComplete {
+					return err
+				}
+				idx++
+				continue
+			}
+			e.trace(start, "element %d skipped", idx)
 			e.Scanner.SkipValue() // skip this item if index doesn't match
 			idx++
 			continue
 		}
-
-		tok, val := e.Scanner.Token()
-		switch tok {
-		case StartObject:
-			if err := e.ExtractObject(node, resultNode); err != nil {
-				return err
+		if node.Neighbor != 0 {
+			if !neighbors[idx] {
+				e.trace(start, "element %d skipped, not next to a filter match", idx)
+				e.Scanner.SkipValue()
+				idx++
+				continue
 			}
-		case StartArray:
-			if err := e.ExtractArray(node, resultNode); err != nil {
-				return err
+		} else if node.Filter != nil {
+			e.Scanner.SkipValue()
+			end := e.Scanner.Pos()
+			if !e.matchesFilter(node.Filter, start) {
+				e.trace(start, "element %d skipped by filter", idx)
+				e.Scanner.pos = end
+				idx++
+				continue
+			}
+			e.Scanner.pos = start
+			if node.MatchIndexed {
+				if matches != want {
+					e.trace(start, "element %d skipped, filter match %d", idx, matches)
+					e.Scanner.pos = end
+					matches++
+					idx++
+					continue
+				}
+				matches++
 			}
-		default:
-			if node.IsTerminal {
-				e.AddResult(node, resultNode, node.ArrayIndex != -1, string(val))
+		}
+		if node.SampleRate > 0 || node.SampleSize > 0 {
+			keep := e.keepSample(node.SampleRate, sampleLeft, elemsLeft)
+			elemsLeft--
+			if !keep {
+				e.trace(start, "element %d skipped by sampling", idx)
+				e.Scanner.SkipValue()
+				idx++
+				continue
 			}
-			e.Scanner.SkipValue() // skip value for non-object/array tokens
+			sampleLeft--
+		}
+
+		e.trace(start, "element %d matched %s", idx, node.Segment)
+		var identity []byte
+		if len(node.Groups) > 0 && e.GroupKey != "" {
+			identity = e.identity(start)
+		}
+		e.pushIndex(idx)
+		var err error
+		if node.AsArray {
+			err = e.extractMatch(node, resultNode)
+		} else {
+			err = e.extractInto(node, resultNode)
+		}
+		if err != nil {
+			return err
+		}
+		e.popPath()
+		if len(node.Groups) > 0 {
+			e.flushGroups(node.Groups, identity)
 		}
 
 		if e.ExtractionComplete {
@@ -276,6 +1898,9 @@ func (e *Extractor) ExtractArray(node *PathNode, resultNode *PathResultWatcher)
 
 		idx++
 	}
+	if node.AsArray && index >= 0 && idx <= index {
+		resultNode.Short, resultNode.Length = true, idx
+	}
 	e.EndArray(node, resultNode)
 
 	if err := e.Scanner.ExpectEndArray(); err != nil {
@@ -284,3 +1909,36 @@ func (e *Extractor) ExtractArray(node *PathNode, resultNode *PathResultWatcher)
 
 	return nil
 }
+
+// ExtractPresence reports for every named path whether it exists in data.
+func ExtractPresence(data []byte, paths map[string]string) (map[string]bool, error) {
+	queries := make(map[string]string, len(paths))
+	for name, query := range paths {
+		queries[name] = presenceQuery(query)
+	}
+	e := NewExtractor(data, CompilePaths(queries))
+	if err := e.Extract(); err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(paths))
+	for name := range paths {
+		present[name] = e.Presence[name]
+	}
+	return present, nil
+}
+
+// presenceQuery adds the '?' of an existence-only path to query, before any
+// suffixes like #first, which addPath cuts first.
+func presenceQuery(query string) string {
+	query = strings.TrimSpace(query)
+	suffixes := ""
+	for _, suffix := range pathSuffixes {
+		if rest, ok := strings.CutSuffix(query, suffix); ok {
+			query, suffixes = rest, suffix+suffixes
+		}
+	}
+	if !strings.HasSuffix(query, "?") {
+		query += "?"
+	}
+	return query + suffixes
+}
@@ -0,0 +1,1446 @@
+package jsonextract
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// extract compiles paths and extracts them from doc, after setup, if given,
+// has configured the extractor.
+func extract(doc string, paths map[string]string, setup func(*Extractor)) (*Extractor, error) {
+	e := NewExtractor([]byte(doc), CompilePaths(paths))
+	if setup != nil {
+		setup(e)
+	}
+	return e, e.Extract()
+}
+
+// checkResults fails the test unless got holds exactly the results in want.
+func checkResults(t *testing.T, got, want map[string][]string) {
+	t.Helper()
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results %v, want %v", got, want)
+	}
+}
+
+func TestConsumed(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want int
+	}{
+		{`{"a":1} {"a":2}`, 7},
+		{`  {"b":{"c":[1,2]},"a":1}{"a":2}`, 25}, // completes before the end
+		{`{"b":2}`, 7},                           // never completes
+		{`[1,2] 3`, 5},
+	}
+	for _, tt := range tests {
+		e, err := extract(tt.doc, map[string]string{"a": "a"}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.doc, err)
+		}
+		if got := e.Consumed(); got != tt.want {
+			t.Errorf("%s: consumed %d, want %d", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestConsumedReportsMalformedRest(t *testing.T) {
+	e, err := extract(`{"o":{"a":1},"b":"oops}`, map[string]string{"a": "o.a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Consumed()
+	if e.Scanner.Err() == nil {
+		t.Error("no error for the unterminated string after the match")
+	}
+}
+
+func TestRecordPaths(t *testing.T) {
+	doc := `{"root":{"items":[{"meta":{"id":1}},{"x":1},{"meta":{"id":3},"ok":true}]},"l":[[1,2],[3]]}`
+	e, err := extract(doc, map[string]string{
+		"id":     "root.items[*].meta.id",
+		"ok":     "root.items[?ok=true].meta.id",
+		"nested": "l[*][*]",
+		"one":    "root.items[0].meta",
+	}, func(e *Extractor) { e.RecordPaths = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Paths, map[string][]string{
+		"id":     {"root.items[0].meta.id", "root.items[2].meta.id"},
+		"ok":     {"root.items[2].meta.id"},
+		"nested": {"l[0][0]", "l[0][1]", "l[1][0]"},
+		"one":    {"root.items[0].meta"},
+	})
+	if len(e.Paths["id"]) != len(e.Results["id"]) {
+		t.Errorf("%d paths for %d results", len(e.Paths["id"]), len(e.Results["id"]))
+	}
+}
+
+func TestRecordPathsOff(t *testing.T) {
+	e, err := extract(`{"a":[1,2]}`, map[string]string{"a": "a[*]"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Paths["a"]) != 0 {
+		t.Errorf("paths recorded without RecordPaths: %v", e.Paths)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	errBad := errors.New("bad value")
+	e, err := extract(`{"name":"ann","blob":"aGVsbG8=","n":[1,2]}`, map[string]string{
+		"name": "name",
+		"blob": "blob",
+		"n":    "n[*]",
+	}, func(e *Extractor) {
+		e.Transforms = map[string]TransformFunc{
+			"name": func(b []byte) ([]byte, error) { return bytes.ToUpper(b), nil },
+			"blob": func(b []byte) ([]byte, error) { return base64.StdEncoding.AppendDecode(nil, b) },
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"name": {"ANN"}, "blob": {"hello"}, "n": {"1", "2"}})
+
+	failing := func(e *Extractor) {
+		e.Transforms = map[string]TransformFunc{
+			"n": func(b []byte) ([]byte, error) {
+				if string(b) == "2" {
+					return nil, errBad
+				}
+				return b, nil
+			},
+		}
+	}
+	_, err = extract(`{"n":[1,2,3]}`, map[string]string{"n": "n[*]"}, failing)
+	if !errors.Is(err, errBad) {
+		t.Errorf("Extract returned %v, want the transform's error", err)
+	}
+
+	e, err = extract(`{"n":[1,2,3]}`, map[string]string{"n": "n[*]"}, func(e *Extractor) {
+		failing(e)
+		e.CollectErrors = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"n": {"1", "3"}})
+	if errs := e.Errors(); len(errs) != 1 || !errors.Is(errs[0], errBad) {
+		t.Errorf("Errors() = %v", errs)
+	}
+}
+
+func TestRootQuery(t *testing.T) {
+	tests := []struct {
+		doc, query, want string
+	}{
+		{`{"a":{"b":1}}`, "$", `{"a":{"b":1}}`},
+		{`{"a":{"b":1}}`, ".", `{"a":{"b":1}}`},
+		{` [1, 2] `, "$", `[1, 2]`},
+		{`7`, ".", `7`},
+		{`"s"`, "$", `s`},
+		{`{"a":{"b":1}}`, "$.a", `{"b":1}`},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"r": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s in %s: %v", test.query, test.doc, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"r": {test.want}})
+	}
+}
+
+func TestEmptyQuery(t *testing.T) {
+	for _, query := range []string{"", "  ", "a.", "a..", ".."} {
+		e, err := extract(`{"a":{"":1},"":2}`, map[string]string{"q": query}, nil)
+		if err != nil {
+			t.Errorf("%q: %v", query, err)
+			continue
+		}
+		if len(e.Root.Children) != 0 {
+			t.Errorf("%q compiled to %d children", query, len(e.Root.Children))
+		}
+		checkResults(t, e.Results, nil)
+
+		if _, err := CompilePathsStrict(map[string]string{"q": query}); err == nil {
+			t.Errorf("CompilePathsStrict accepted %q", query)
+		}
+	}
+}
+
+func TestStrictNumbers(t *testing.T) {
+	strict := func(e *Extractor) { e.StrictNumbers = true }
+	valid := []struct{ doc, want string }{
+		{`{"a":0}`, "0"},
+		{`{"a":-12}`, "-12"},
+		{`{"a":1.5e10}`, "1.5e10"},
+		{`{"a":2E-3}`, "2E-3"},
+		{`{"a":-0.5e+2}`, "-0.5e+2"},
+		{`{"a":+1}`, "1"},
+	}
+	for _, test := range valid {
+		e, err := extract(test.doc, map[string]string{"a": "a"}, strict)
+		if err != nil {
+			t.Errorf("%s: %v", test.doc, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"a": {test.want}})
+	}
+
+	for _, doc := range []string{`{"a":01}`, `{"a":-01.5}`, `{"a":1.2.3}`, `{"a":1.}`, `{"a":.5}`, `{"a":1e}`, `{"a":1e5e5}`, `{"a":-}`} {
+		if _, err := extract(doc, map[string]string{"a": "a"}, strict); err == nil {
+			t.Errorf("%s accepted", doc)
+		}
+	}
+
+	// without StrictNumbers malformed numbers are stored as written, and
+	// '+' is not a number at all
+	e, err := extract(`{"a":01}`, map[string]string{"a": "a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"01"}})
+	if _, err := extract(`{"a":+1}`, map[string]string{"a": "a"}, nil); err == nil {
+		t.Error(`{"a":+1} accepted without StrictNumbers`)
+	}
+}
+
+func TestStrictNumbersCollectErrors(t *testing.T) {
+	e, err := extract(`{"a":[1,01,2]}`, map[string]string{"a": "a[*]"}, func(e *Extractor) {
+		e.StrictNumbers = true
+		e.CollectErrors = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1", "2"}})
+	if len(e.Errors()) != 1 {
+		t.Errorf("Errors() = %v", e.Errors())
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301" // é precomposed and with a combining accent
+	tests := []struct {
+		doc, query string
+	}{
+		{`{"` + nfd + `":1}`, nfc},
+		{`{"` + nfc + `":1}`, nfd},
+		{`{"m":{"` + nfd + `":1}}`, "m." + nfc},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"k": test.query}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, e.Results, nil) // different bytes without normalization
+
+		e, err = extract(test.doc, map[string]string{"k": test.query}, func(e *Extractor) {
+			e.NormalizeKeys = true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, e.Results, map[string][]string{"k": {"1"}})
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	doc := []byte(`{"items":[{"id":1,"name":"a\"b"},{"id":2,"name":"c"}],"ok":true,"none":null}`)
+	paths := map[string]string{"id": "items[*].id", "name": "items[*].name", "ok": "ok", "none": "none"}
+
+	want := NewExtractor(doc, CompilePaths(paths))
+	if err := want.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExtractor(doc, CompilePaths(paths))
+	e.ZeroCopy = true
+	if err := e.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Results) != 0 {
+		t.Errorf("ZeroCopy filled Results: %v", e.Results)
+	}
+	got := make(map[string][]string)
+	for name, values := range e.ResultsBytes {
+		for _, value := range values {
+			got[name] = append(got[name], string(value))
+		}
+	}
+	checkResults(t, got, want.Results)
+
+	// the slices alias the document
+	name := e.ResultsBytes["name"][1]
+	doc[bytes.Index(doc, []byte(`"c"`))+1] = 'z'
+	if string(name) != "z" {
+		t.Errorf("result %q does not alias RawData", name)
+	}
+}
+
+// benchDoc returns a document of n items with a few fields each.
+func benchDoc(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"items":[`)
+	for i := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"name":"item %d","tags":["x","y"],"price":%d.5}`, i, i, i)
+	}
+	b.WriteString(`]}`)
+	return b.Bytes()
+}
+
+func benchmarkExtract(b *testing.B, setup func(*Extractor)) {
+	doc := benchDoc(1000)
+	paths := CompilePaths(map[string]string{"id": "items[*].id", "name": "items[*].name"})
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		e := NewExtractor(doc, paths)
+		if setup != nil {
+			setup(e)
+		}
+		if err := e.Extract(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	benchmarkExtract(b, nil)
+}
+
+func BenchmarkExtractZeroCopy(b *testing.B) {
+	benchmarkExtract(b, func(e *Extractor) { e.ZeroCopy = true })
+}
+
+func TestPresence(t *testing.T) {
+	doc := `{"featureFlags":{"beta":false,"dark":null},"limits":{"max":[1,2]},"l":[{"b":1},{"c":2}]}`
+	e, err := extract(doc, map[string]string{
+		"beta":   "featureFlags.beta?",
+		"dark":   "featureFlags.dark?",
+		"limits": "limits?",
+		"max":    "limits.max?",
+		"gamma":  "featureFlags.gamma?",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"beta": true, "dark": true, "limits": true, "max": true}
+	if !reflect.DeepEqual(e.Presence, want) {
+		t.Errorf("Presence = %v, want %v", e.Presence, want)
+	}
+	checkResults(t, e.Results, nil)
+}
+
+func TestPresenceCompletesEarly(t *testing.T) {
+	// the malformed rest is never read once every path is known present
+	e, err := extract(`{"a":{"b":[1,2]},"c":`, map[string]string{"b": "a.b?"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Presence["b"] || !e.ExtractionComplete {
+		t.Errorf("Presence = %v, complete %v", e.Presence, e.ExtractionComplete)
+	}
+}
+
+func TestExtractPresence(t *testing.T) {
+	doc := []byte(`{"a":{"b":1},"l":[{"b":1},{"c":2}],"o":{"x":1}}`)
+	got, err := ExtractPresence(doc, map[string]string{
+		"b":      "a.b",
+		"c":      "a.c",
+		"first":  "l[*].b#first",
+		"last":   "l[*].c#last",
+		"keys":   "o.*#keys",
+		"array":  "l[*].c#array",
+		"none":   "l[*].d#first",
+		"marked": "a.b?",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"b": true, "c": false, "first": true, "last": true, "keys": true, "array": true, "none": false, "marked": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractPresence = %v, want %v", got, want)
+	}
+}
+
+func TestSizeHints(t *testing.T) {
+	e, err := extract(`{"a":[1,2],"b":[3]}`, map[string]string{"a": "a[*]", "b": "b[*]", "c": "c"}, func(e *Extractor) {
+		e.SizeHint = 8
+		e.SizeHints = map[string]int{"b": 100}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1", "2"}, "b": {"3"}})
+	if c := cap(e.Results["a"]); c != 8 {
+		t.Errorf("cap(a) = %d, want 8", c)
+	}
+	if c := cap(e.Results["b"]); c != 100 {
+		t.Errorf("cap(b) = %d, want 100", c)
+	}
+}
+
+func BenchmarkExtractSizeHint(b *testing.B) {
+	benchmarkExtract(b, func(e *Extractor) { e.SizeHint = 1000 })
+}
+
+func TestKeyWildcard(t *testing.T) {
+	doc := `{"core":{"version":"1.0"},"ui":{"x":1,"version":"2.1"},"n":5,"s":"version","none":null,"empty":{},
+	"deep":{"inner":{"version":"9"}}}`
+	e, err := extract(doc, map[string]string{"v": "*.version", "inner": "deep.*.version"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"v": {"1.0", "2.1"}, "inner": {"9"}})
+}
+
+func TestCapturePairs(t *testing.T) {
+	doc := `{"data":{"metric.cpu.0":1.5,"metric.cpu.1":2,"metric.mem":7,"other":{"metric.cpu.9":3}},
+	"hosts":{"a":{"up":true},"b":{"up":false}}}`
+	e, err := extract(doc, map[string]string{
+		"cpu":    `data."metric.cpu."*`,
+		"metric": "data.metric*",
+		"up":     "hosts.*.up",
+	}, func(e *Extractor) { e.CapturePairs = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"cpu":    {"1.5", "2"},
+		"metric": {"1.5", "2", "7"},
+		"up":     {"true", "false"},
+	})
+	want := map[string][]KeyValue{
+		"cpu":    {{"metric.cpu.0", "1.5"}, {"metric.cpu.1", "2"}},
+		"metric": {{"metric.cpu.0", "1.5"}, {"metric.cpu.1", "2"}, {"metric.mem", "7"}},
+		"up":     {{"a", "true"}, {"b", "false"}}, // the key of the '*' above the result
+	}
+	if !reflect.DeepEqual(e.Pairs, want) {
+		t.Errorf("Pairs = %v, want %v", e.Pairs, want)
+	}
+}
+
+func TestDottedKeys(t *testing.T) {
+	doc := `{"data":{"metric.cpu.0":1.5,"metric.cpu.1":2,"metric":{"cpu":{"x":4}}}}`
+	e, err := extract(doc, map[string]string{
+		"quoted": `data."metric.cpu."*`,
+		"nested": "data.metric.cpu.*",
+		"exact":  `data."metric.cpu.1"`,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"quoted": {"1.5", "2"},
+		"nested": {"4"}, // unquoted dots separate keys
+		"exact":  {"2"},
+	})
+}
+
+func TestRootValueEnd(t *testing.T) {
+	tests := []struct {
+		doc      string
+		want     []string
+		consumed int
+	}{
+		{"{\"a\":1}  \n\t", []string{"1"}, 7},
+		{`{"a":1}{"a":2}`, []string{"1"}, 7},
+		{"[{\"a\":1},{\"a\":2}]\n[{\"a\":3}]", []string{"1"}, 17},
+		{`{"b":1} {"a":2}`, nil, 7},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"a": "a"}, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.doc, err)
+			continue
+		}
+		want := map[string][]string{"a": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+		if got := e.Consumed(); got != test.consumed {
+			t.Errorf("%q: consumed %d, want %d", test.doc, got, test.consumed)
+		}
+	}
+
+	for _, doc := range []string{`}`, ` ]`, `]{"a":1}`} {
+		if _, err := extract(doc, map[string]string{"a": "a"}, nil); err == nil {
+			t.Errorf("%q: stray closer accepted", doc)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	e, err := extract(`{"user":{"name":"ann","tags":[]}}`, map[string]string{
+		"name":  "user.name",
+		"city":  "user.city",
+		"tags":  "user.tags[*]",
+		"plain": "user.none",
+	}, func(e *Extractor) {
+		e.Defaults = map[string]string{"name": "nobody", "city": "unknown", "tags": "none"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"name": {"ann"},
+		"city": {"unknown"},
+		"tags": {"none"},
+	})
+	if cov := e.Coverage(); cov["city"] {
+		t.Error("a default counted as a match")
+	}
+}
+
+func TestDefaultsZeroCopy(t *testing.T) {
+	e, err := extract(`{"a":1}`, map[string]string{"a": "a", "b": "b"}, func(e *Extractor) {
+		e.ZeroCopy = true
+		e.Defaults = map[string]string{"a": "0", "b": "2"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.ResultsBytes; len(got["a"]) != 1 || string(got["a"][0]) != "1" || len(got["b"]) != 1 || string(got["b"][0]) != "2" {
+		t.Errorf("ResultsBytes = %q", got)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var trace strings.Builder
+	_, err := extract("{\"a\":{\"b\":1},\n\"x\":0,\"c\":[1,{\"d\":2}]}", map[string]string{"b": "a.b", "d": "c[*].d"}, func(e *Extractor) {
+		e.Trace = &trace
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`1:2 key "a" matched a`,
+		`1:7 key "b" matched b`,
+		`1:11 Number "1" stored for b`,
+		`2:1 key "x" skipped`,
+		`2:7 key "c" matched c[*]`,
+		`2:12 element 0 matched c[*]`,
+		`2:14 element 1 matched c[*]`,
+		`2:15 key "d" matched d`,
+		`2:19 Number "2" stored for d`,
+	}
+	if got := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace\n%s\nwant\n%s", trace.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestArraySuffix(t *testing.T) {
+	doc := `{"items":[{"id":1, "n":"a"},{"id":2}],"s":[1, "x" ,null,true],"e":[],"f":[{"t":1},{"t":2}]}`
+	e, err := extract(doc, map[string]string{
+		"objects": "items[*]#array",
+		"scalars": "s[*]#array",
+		"ids":     "items[*].id#array",
+		"filter":  "f[?t=2]#array",
+		"empty":   "e[*]#array",
+		"missing": "missing[*]#array",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"objects": {`[{"id":1, "n":"a"},{"id":2}]`}, // elements as written
+		"scalars": {`[1,"x",null,true]`},
+		"ids":     {`[1,2]`},
+		"filter":  {`[{"t":2}]`},
+		"empty":   {`[]`},
+		"missing": {`[]`},
+	})
+	if Validate([]byte(e.Results["scalars"][0])) != nil {
+		t.Errorf("%s is not valid JSON", e.Results["scalars"][0])
+	}
+}
+
+func TestNullResults(t *testing.T) {
+	e, err := extract(`{"n":null,"s":"","l":[null,"",0,false]}`, map[string]string{"n": "n", "s": "s", "l": "l[*]"}, func(e *Extractor) {
+		e.RecordTypes = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"n": {"null"}, "s": {""}, "l": {"null", "", "0", "false"}})
+	want := map[string][]TokenType{"n": {Null}, "s": {String}, "l": {Null, String, Number, Boolean}}
+	if !reflect.DeepEqual(e.Types, want) {
+		t.Errorf("Types = %v, want %v", e.Types, want)
+	}
+}
+
+func TestCompilePathsMulti(t *testing.T) {
+	doc := `{"users":[{"id":1},{"id":2}],"meta":{"owner":{"id":9}},"admins":[{"id":3}]}`
+	root := CompilePathsMulti(map[string][]string{
+		"ids":   {"admins[*].id", "users[*].id", "meta.owner.id"},
+		"owner": {"meta.owner.id"},
+	})
+	e := NewExtractor([]byte(doc), root)
+	if err := e.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"ids":   {"1", "2", "9", "3"}, // document order, not query order
+		"owner": {"9"},
+	})
+}
+
+func TestTerminalNames(t *testing.T) {
+	root := CompilePaths(map[string]string{
+		"name":  "user.name",
+		"city":  "user.address.city",
+		"ids":   "items[*].id",
+		"tags":  "items[*].tags[*]",
+		"keys":  "*#keys",
+		"root":  "$",
+		"empty": "",
+	})
+	if got, want := root.TerminalNames(), []string{"city", "ids", "keys", "name", "root", "tags"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TerminalNames() = %v, want %v", got, want)
+	}
+
+	root = CompilePathsMulti(map[string][]string{"id": {"a.id", "b[*].id"}, "x": {"x"}})
+	if got, want := root.TerminalNames(), []string{"id", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TerminalNames() = %v, want %v", got, want)
+	}
+}
+
+func TestFilteredElementCapture(t *testing.T) {
+	doc := `{"orders":[{"id":1,"status":"active","items":[1,2]},{"id":2,"status":"done"},
+	{"id":3, "status":"active"}],"users":[{"name":"a","verified":true},{"name":"b","verified":false}]}`
+	e, err := extract(doc, map[string]string{
+		"active":   "orders[?status=active]",
+		"verified": "users[?verified=true]",
+		"none":     "orders[?status=gone]",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"active":   {`{"id":1,"status":"active","items":[1,2]}`, `{"id":3, "status":"active"}`},
+		"verified": {`{"name":"a","verified":true}`},
+	})
+}
+
+func TestCoverage(t *testing.T) {
+	e, err := extract(`{"user":{"name":"ann","tags":[]},"items":[{"id":1}]}`, map[string]string{
+		"name":  "user.name",
+		"email": "user.email",
+		"tags":  "user.tags[*]",
+		"ids":   "items[*].id",
+		"all":   "items[*].id#array",
+		"none":  "none[*]#array",
+	}, func(e *Extractor) {
+		e.Defaults = map[string]string{"email": "-"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"name": true, "email": false, "tags": false, "ids": true, "all": true, "none": false}
+	if got := e.Coverage(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Coverage() = %v, want %v", got, want)
+	}
+}
+
+func TestNewExtractorRange(t *testing.T) {
+	data := []byte("HTTP/1.1 200 OK\r\n\r\n{\"user\":{\"id\":7,\"tags\":[\"a\"]}}\r\n--boundary--")
+	start := bytes.IndexByte(data, '{')
+	end := bytes.LastIndexByte(data, '}') + 1
+	paths := CompilePaths(map[string]string{"id": "user.id", "tags": "user.tags", "root": "$"})
+
+	e := NewExtractorRange(data, start, end, paths)
+	if err := e.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"id":   {"7"},
+		"tags": {`["a"]`},
+		"root": {string(data[start:end])},
+	})
+
+	// an end below zero reads to the end of data, noise included
+	e = NewExtractorRange([]byte(`xx[1,2]`), 2, -1, CompilePaths(map[string]string{"v": "[1]"}))
+	if err := e.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"v": {"2"}})
+
+	// the range bounds the scan, so a value cut by it is malformed
+	e = NewExtractorRange(data, start, end-1, paths)
+	var perr *ParseError
+	if err := e.Extract(); !errors.As(err, &perr) || perr.Offset < start || perr.Offset > end {
+		t.Errorf("cut range: got %v", err)
+	}
+}
+
+func FuzzExtract(f *testing.F) {
+	seeds := []string{
+		`{"a":{"b":{"c":[1,{"d":[2,[3,[4]]]}]}},"e":[{"f":1},{"f":2}]}`,
+		strings.Repeat(`{"a":[`, 50) + strings.Repeat(`]}`, 50),
+		`{"a":{"b":[1,2`,
+		`{"a":"unterminated`,
+		`{"a":"x\`,
+		`{"a`,
+		`[1,2,`,
+		`{"a":"\\\\\\\"\"\\\u0041\uD83D\uDE00\/\b\f\n\r\t"}`,
+		`{"a\"b\\":"\"\\\"\\\\\""}`,
+		`{"e":[{"f":"\\"},{"f":"]}\""}]}`,
+		`{"a":+1,"b":01,"c":1.2.3,"d":-}`,
+		`{"a":tru,"b":nul}`,
+		"\xEF\xBB\xBF{\"a\":1}",
+		`}`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed), false)
+		f.Add([]byte(seed), true)
+	}
+	f.Add([]byte(`{a:NaN,/*x*/"b":[1,2,],}// end`), true)
+	paths := CompilePaths(map[string]string{
+		"a":     "a",
+		"deep":  "a.b.c[*].d[1][0]",
+		"f":     "e[*].f",
+		"first": "e[?f=2]#first",
+		"any":   "..f",
+		"keys":  "*#keys",
+		"root":  "$",
+	})
+	f.Fuzz(func(t *testing.T, data []byte, lenient bool) {
+		e := NewExtractor(data, paths)
+		e.RecordPaths = true
+		e.Scanner.Lenient, e.Scanner.AllowComments = lenient, lenient
+		err := e.Extract()
+		var perr *ParseError
+		if errors.As(err, &perr) && (perr.Offset < 0 || perr.Offset > len(data)) {
+			t.Errorf("error offset %d outside %d bytes", perr.Offset, len(data))
+		}
+		if pos := e.Scanner.Pos(); pos < 0 || pos > len(data) {
+			t.Errorf("scanner at %d of %d bytes", pos, len(data))
+		}
+		if err == nil {
+			if n := e.Consumed(); n < 0 || n > len(data) {
+				t.Errorf("consumed %d of %d bytes", n, len(data))
+			}
+		}
+		Validate(data)
+	})
+}
+
+func TestArrayLength(t *testing.T) {
+	doc := `{"orders":[1,2,3],"empty":[],"m":[[1,2],[],[3]],"o":{"x":1},"s":"str","l":[{"a":[1]},{"a":[1,2]}]}`
+	e, err := extract(doc, map[string]string{
+		"orders":  "orders.#",
+		"empty":   "empty.#",
+		"outer":   "m.#",
+		"inner":   "m[*].#",
+		"first":   "m[0].#",
+		"nested":  "l[*].a.#",
+		"object":  "o.#",
+		"string":  "s.#",
+		"missing": "none.#",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"orders": {"3"},
+		"empty":  {"0"},
+		"outer":  {"3"},
+		"inner":  {"2", "0", "1"},
+		"first":  {"2"},
+		"nested": {"1", "2"},
+	})
+}
+
+func TestTerminalKeyWildcard(t *testing.T) {
+	flat := `{"config":{"debug":true,"level":"info","retries":3}}`
+	mixed := `{"config":{"a":1,"b":"x","c":{"d":1},"e":[1],"f":null}}`
+	tests := []struct {
+		name    string
+		doc     string
+		scalars bool
+		want    []string
+	}{
+		{"flat", flat, false, []string{"true", "info", "3"}},
+		{"flat scalars", flat, true, []string{"true", "info", "3"}},
+		{"mixed", mixed, false, []string{"1", "x", `{"d":1}`, "[1]", "null"}}, // containers as raw JSON
+		{"mixed scalars", mixed, true, []string{"1", "x", "null"}},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"all": "config.*"}, func(e *Extractor) {
+			e.ScalarWildcards = test.scalars
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkResults(t, e.Results, map[string][]string{"all": test.want})
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`{"events":[`)
+	for i := range 1000 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(i))
+	}
+	b.WriteString(`]}`)
+	doc := b.String()
+
+	sample := func(query string) []string {
+		t.Helper()
+		e, err := extract(doc, map[string]string{"s": query}, func(e *Extractor) {
+			e.Rand = rand.New(rand.NewPCG(1, 2))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.Results["s"]
+	}
+	tests := []struct {
+		query    string
+		min, max int
+	}{
+		{"events[*~0.1]", 70, 130},
+		{"events[*~100]", 100, 100},
+		{"events[*~1]", 1, 1},
+		{"events[*~2000]", 1000, 1000},
+		{"events[*~1.0]", 1000, 1000},
+	}
+	for _, test := range tests {
+		got := sample(test.query)
+		if len(got) < test.min || len(got) > test.max {
+			t.Errorf("%s kept %d elements, want %d to %d", test.query, len(got), test.min, test.max)
+		}
+		if again := sample(test.query); !reflect.DeepEqual(got, again) {
+			t.Errorf("%s: the same seed gave different samples", test.query)
+		}
+		last := -1
+		for _, v := range got {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= last || n >= 1000 {
+				t.Errorf("%s: %s is not a later element than %d", test.query, v, last)
+				break
+			}
+			last = n
+		}
+	}
+}
+
+func TestCompletedEarly(t *testing.T) {
+	paths := map[string]string{"a": "o.a"}
+	tests := []struct {
+		name    string
+		doc     string
+		scanAll bool
+		early   bool
+		fails   bool
+	}{
+		{"early", `{"o":{"a":1},"b":[1,2,3]}`, false, true, false},
+		{"malformed rest unread", `{"o":{"a":1},"b":[1,2,}`, false, true, false},
+		{"scan all", `{"o":{"a":1},"b":[1,2,3]}`, true, false, false},
+		{"scan all finds error", `{"o":{"a":1},"b":[1,2,}`, true, false, true},
+		{"scan all checks strings", `{"o":{"a":1},"b":"\x"}`, true, false, true},
+		{"last key", `{"b":2,"o":{"a":1}}`, false, true, false},
+		{"no match", `{"b":2}`, false, false, false},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, paths, func(e *Extractor) { e.ScanAll = test.scanAll })
+		if (err != nil) != test.fails {
+			t.Errorf("%s: error %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := e.CompletedEarly(); got != test.early {
+			t.Errorf("%s: CompletedEarly() = %v, want %v", test.name, got, test.early)
+		}
+	}
+}
+
+func TestVerbatim(t *testing.T) {
+	doc := `{"s":"a\"bé","n":-1.5e3,"t":true,"f":false,"z":null,"o":{"a": [1, 2]},"l":[ "x" , 2 ]}`
+	e, err := extract(doc, map[string]string{
+		"s": "s", "n": "n", "t": "t", "f": "f", "z": "z", "o": "o", "l": "l[*]", "len": "l.#",
+	}, func(e *Extractor) {
+		e.Verbatim = true
+		e.UnescapeStrings = true // no effect on verbatim results
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"s":   {`"a\"bé"`},
+		"n":   {"-1.5e3"},
+		"t":   {"true"},
+		"f":   {"false"},
+		"z":   {"null"},
+		"o":   {`{"a": [1, 2]}`},
+		"l":   {`"x"`, "2"},
+		"len": {"2"}, // not a value in the source
+	}
+	checkResults(t, e.Results, want)
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	doc := `{"a":1,"b":{"c":2},"a":3,"b":{"c":4},"l":[{"k":1,"k":2}],"o":{"x":5,"x":6},"d":[7],"d":[8]}`
+	tests := []struct {
+		paths map[string]string
+		want  map[string][]string
+	}{
+		{map[string]string{"a": "a"}, map[string][]string{"a": {"1", "3"}}},
+		{map[string]string{"x": "o.x"}, map[string][]string{"x": {"5", "6"}}},
+		{map[string]string{"d": "d[*]"}, map[string][]string{"d": {"7", "8"}}},
+		{map[string]string{"d": "d[0]"}, map[string][]string{"d": {"7", "8"}}},
+		{map[string]string{"a": "a", "c": "b.c", "k": "l[*].k"}, map[string][]string{"a": {"1", "3"}, "c": {"2", "4"}, "k": {"1", "2"}}},
+		{map[string]string{"a": "a#first"}, map[string][]string{"a": {"1"}}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, test.paths, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, e.Results, test.want)
+	}
+
+	// a single-valued path completes at the end of the object holding its key
+	e, err := extract(`{"o":{"x":1,"x":2},"rest":[1,2,}`, map[string]string{"x": "o.x"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"x": {"1", "2"}})
+	if !e.CompletedEarly() {
+		t.Error("extraction did not complete at the end of the object")
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	collect := func(e *Extractor) {
+		e.CollectErrors = true
+		e.StrictNumbers = true
+		e.UnescapeStrings = true
+	}
+	e, err := extract(`{"a":[1,01,"x\q",3],"b":"ok","c":1.2.3}`, map[string]string{"a": "a[*]", "b": "b", "c": "c"}, collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1", "3"}, "b": {"ok"}})
+	var offsets []int
+	for _, err := range e.Errors() {
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		offsets = append(offsets, perr.Offset)
+	}
+	if want := []int{8, 11, 33}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("errors at %v, want %v: %v", offsets, want, e.Errors())
+	}
+
+	// structural errors still end the extraction
+	e, err = extract(`{"a":[1,2}`, map[string]string{"a": "a[*]"}, collect)
+	if err == nil || len(e.Errors()) != 0 {
+		t.Errorf("got %v with collected %v", err, e.Errors())
+	}
+
+	// without CollectErrors the first bad value ends the extraction
+	if _, err := extract(`{"a":[1,01,3]}`, map[string]string{"a": "a[*]"}, func(e *Extractor) { e.StrictNumbers = true }); err == nil {
+		t.Error("bad value accepted")
+	}
+}
+
+func TestScalarRoot(t *testing.T) {
+	tests := []struct {
+		doc, want string
+		typ       TokenType
+	}{
+		{`42`, "42", Number},
+		{` -1.5e3 `, "-1.5e3", Number},
+		{`"hello"`, "hello", String},
+		{`true`, "true", Boolean},
+		{`false`, "false", Boolean},
+		{`null`, "null", Null},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"root": "$", "a": "a", "i": "[0]"}, func(e *Extractor) {
+			e.RecordTypes = true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.doc, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"root": {test.want}})
+		if got := e.Types["root"]; len(got) != 1 || got[0] != test.typ {
+			t.Errorf("%s: type %v, want %v", test.doc, got, test.typ)
+		}
+	}
+
+	for _, doc := range []string{`tru`, `"open`, `nul`} {
+		if _, err := extract(doc, map[string]string{"root": "$"}, nil); err == nil {
+			t.Errorf("%s accepted", doc)
+		}
+	}
+}
+
+func TestOnMissing(t *testing.T) {
+	paths := map[string]string{"name": "user.name", "city": "user.city", "zip": "user.zip", "tags": "user.tags[*]"}
+	tests := []struct {
+		doc  string
+		want []string // nil when OnMissing should not be called
+	}{
+		{`{"user":{"name":"ann","tags":[]}}`, []string{"city", "tags", "zip"}},
+		{`{"user":{"name":"ann","city":"x","zip":1,"tags":[1]}}`, nil},
+		{`{}`, []string{"city", "name", "tags", "zip"}},
+	}
+	for _, test := range tests {
+		var got []string
+		called := false
+		_, err := extract(test.doc, paths, func(e *Extractor) {
+			e.Defaults = map[string]string{"city": "unknown"}
+			e.OnMissing = func(names []string) { called, got = true, names }
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.doc, err)
+			continue
+		}
+		if called != (test.want != nil) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: missing %v (called %v), want %v", test.doc, got, called, test.want)
+		}
+	}
+	_, err := extract(`{"user":{"name":`, paths, func(e *Extractor) {
+		e.OnMissing = func([]string) { t.Error("called after a failed Extract") }
+	})
+	if err == nil {
+		t.Error("truncated document: no error")
+	}
+}
+
+func TestEscapedKeys(t *testing.T) {
+	doc := `{"a\/b":1,"q\"k":2,"été":3,"x.y":{"c\/d":4},
+	"list":[{"k\/1":"v","n":5},{"k/1":"w","n":6}],"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`a/b`, []string{"1"}},
+		{`"a/b"`, []string{"1"}},
+		{`"a\/b"`, []string{"1"}},
+		{`"q\"k"`, []string{"2"}},
+		{`été`, []string{"3"}},
+		{`"x.y"."c/d"`, []string{"4"}},
+		{`list[?k/1=v].n`, []string{"5"}},
+		{`list[?"k/1"=w].n`, []string{"6"}},
+	}
+	for _, test := range tests {
+		// "z" keeps the extraction going past the key under test
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want, "z": {"0"}})
+	}
+}
+
+func TestMetaSelectors(t *testing.T) {
+	doc := `{"items":[1,{"a":1},[2,3],"s",null],"o":{"x":1,"y":{"q":2},"@type":"T"},"n":5,"e":{},"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"items.@length", []string{"5"}},
+		{"o.@length", []string{"3"}},
+		{"e.@length", []string{"0"}},
+		{"@length", []string{"5"}},
+		{"o.@keys", []string{"x", "y", "@type"}},
+		{"e.@keys", nil},
+		{"o.@type", []string{"object"}},
+		{"items[*].@type", []string{"number", "object", "array", "string", "null"}},
+		{"@type", []string{"object"}},
+		{`o."@type"`, []string{"T"}}, // a member named @type
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"z": {"0"}}
+		if test.want != nil {
+			want["v"] = test.want
+		}
+		checkResults(t, e.Results, want)
+	}
+
+	// mismatched targets fail like malformed values
+	mismatched := []struct {
+		query  string
+		offset int
+		want   []string // results with CollectErrors
+	}{
+		{"n.@length", 76, nil},
+		{"n.@keys", 76, nil},
+		{"items.@keys", 9, nil},
+		{"items[*].@length", 10, []string{"1", "2"}},
+	}
+	for _, test := range mismatched {
+		paths := map[string]string{"v": test.query, "z": "z"}
+		_, err := extract(doc, paths, nil)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Offset != test.offset {
+			t.Errorf("%s: error %v, want one at offset %d", test.query, err, test.offset)
+		}
+		e, err := extract(doc, paths, func(e *Extractor) { e.CollectErrors = true })
+		if err != nil || len(e.Errors()) == 0 {
+			t.Errorf("%s: collected %v, %v", test.query, e.Errors(), err)
+			continue
+		}
+		want := map[string][]string{"z": {"0"}}
+		if test.want != nil {
+			want["v"] = test.want
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestIgnoreKeys(t *testing.T) {
+	doc := `{"meta":{"id":1},"rawPayload":{"id":[2]},"list":[{"rawPayload":{"id":3},"id":4}],"raw\/x":{"id":5},"z":0}`
+	paths := map[string]string{"id": "..id", "blob": "rawPayload.id", "x": `"raw/x".id`, "z": "z"}
+	e, err := extract(doc, paths, func(e *Extractor) {
+		e.IgnoreKeys = map[string]bool{"rawPayload": true, "raw/x": true}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"id": {"1", "4"}, "z": {"0"}})
+
+	var out strings.Builder
+	e = NewExtractor([]byte(doc), CompilePaths(map[string]string{"blob": "rawPayload", "z": "z"}))
+	e.IgnoreKeys = map[string]bool{"rawPayload": true}
+	if err := e.Project(&out); err != nil || out.String() != `{"z":0}` {
+		t.Errorf("projected %s, %v", out.String(), err)
+	}
+}
+
+func TestMissingValues(t *testing.T) {
+	tests := []struct {
+		doc    string
+		offset int
+	}{
+		{`{"a":[,]}`, 6},
+		{`{"a":[,1]}`, 6},
+		{`{"a":[1,,2]}`, 8},
+		{`{"a":[1 , ,2]}`, 10},
+		{`{"a":[1,]}`, 8},
+		{`{"a":}`, 5},
+		{`{"a":,1}`, 5},
+	}
+	for _, test := range tests {
+		for _, query := range []string{"a[*]", "a[1]", "a.#", "a[?=2]"} {
+			_, err := extract(test.doc, map[string]string{"v": query}, nil)
+			var perr *ParseError
+			if !errors.As(err, &perr) || perr.Offset != test.offset {
+				t.Errorf("%s %s: error %v, want one at offset %d", test.doc, query, err, test.offset)
+			}
+		}
+	}
+
+	for _, doc := range []string{`{"a":[1]}`, `{"a":[]}`} {
+		if _, err := extract(doc, map[string]string{"v": "a[*]", "n": "a.#"}, nil); err != nil {
+			t.Errorf("%s: %v", doc, err)
+		}
+	}
+	e, err := extract(`{"a":[1,]}`, map[string]string{"v": "a[*]"}, lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"v": {"1"}})
+}
+
+func TestVerbatimKeys(t *testing.T) {
+	doc := `{"o":{"a\"b":1, "c\/d" :2,"é":3,"plain":4},"z":0}`
+	tests := []struct {
+		setup func(*Extractor)
+		want  []string
+	}{
+		{nil, []string{`a\"b`, `c\/d`, `é`, "plain"}},
+		{func(e *Extractor) { e.UnescapeStrings = true }, []string{`a"b`, "c/d", "é", "plain"}},
+		{func(e *Extractor) { e.Verbatim = true }, []string{`"a\"b"`, `"c\/d"`, `"é"`, `"plain"`}},
+	}
+	for i, test := range tests {
+		e, err := extract(doc, map[string]string{"keys": "o.@keys", "len": "o.@length", "z": "z"}, test.setup)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"keys": test.want, "len": {"4"}, "z": {"0"}})
+	}
+}
+
+func TestNestedWildcards(t *testing.T) {
+	doc := `{"data":[{"values":[1,2]},{"values":[]},{"x":1},{"values":[3,[4],{"a":5}]}],
+	"m":[[[1,2],[3]],[[4]]],"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"data[*].values[*]", []string{"1", "2", "3", "[4]", `{"a":5}`}},
+		{"data[*].values[*]#group", []string{"[1,2]", "[]", "[]", `[3,[4],{"a":5}]`}},
+		{"data[*].values[0]#group", []string{"[1]", "[]", "[]", "[3]"}},
+		{"data[1].values[*]#group", []string{"[]"}},
+		{"m[*][*][*]", []string{"1", "2", "3", "4"}},
+		{"m[*][*][*]#group", []string{"[1,2,3]", "[4]"}}, // grouped by the outermost array
+		{"z#group", []string{"[0]"}},                     // no array above: like #array
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want, "z": {"0"}})
+	}
+
+	merged := MergeTrees(CompilePaths(map[string]string{"g": "data[*].values[*]#group"}), CompilePaths(map[string]string{"z": "z"}))
+	e := NewExtractor([]byte(doc), merged)
+	if err := e.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"g": {"[1,2]", "[]", "[]", `[3,[4],{"a":5}]`}, "z": {"0"}})
+}
+
+func TestEmbeddedJSON(t *testing.T) {
+	doc := `{"payload":"{\"id\":5,\"tags\":[\"a\",\"b\"],\"inner\":\"{\\\"k\\\":\\\"v\\\"}\"}",
+	"plain":{"id":6},"bad":"not json","z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"payload~.id", []string{"5"}},
+		{"payload~.tags[*]", []string{"a", "b"}},
+		{"payload~.inner~.k", []string{"v"}}, // double-encoded
+		{"payload~.inner.k", nil},            // the inner string is not decoded
+		{"payload~.inner", []string{`{\"k\":\"v\"}`}},
+		{"payload~", []string{`{"id":5,"tags":["a","b"],"inner":"{\"k\":\"v\"}"}`}},
+		{"plain~.id", []string{"6"}}, // not a string: matched as it is
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"z": {"0"}}
+		if test.want != nil {
+			want["v"] = test.want
+		}
+		checkResults(t, e.Results, want)
+	}
+
+	// a string that is not JSON is a malformed value
+	paths := map[string]string{"v": "bad~.id", "z": "z"}
+	_, err := extract(doc, paths, nil)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Offset != strings.Index(doc, `"not json"`) {
+		t.Errorf("error %v, want one at the bad string", err)
+	}
+	e, err := extract(doc, paths, func(e *Extractor) { e.CollectErrors = true })
+	if err != nil || len(e.Errors()) != 1 {
+		t.Fatalf("collected %v, %v", e.Errors(), err)
+	}
+	checkResults(t, e.Results, map[string][]string{"z": {"0"}})
+}
+
+func TestNormalizeNumbers(t *testing.T) {
+	doc := `{"a":[1.50,1.5,15e-1,100,1e6,-0,0.1,2E+2,-3.0,"1.50",12345678901234567891,1e500]}`
+	normalize := func(e *Extractor) { e.NormalizeNumbers = true }
+	e, err := extract(doc, map[string]string{"v": "a[*]"}, normalize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"v": {
+		"1.5", "1.5", "1.5", "100", "1e+06", "-0", "0.1", "200", "-3",
+		"1.50",                   // a string, not a number
+		"1.2345678901234567e+19", // beyond float64 precision
+		"1e500",                  // out of range: kept as written
+	}})
+
+	e, err = extract(`{"a":[1.50]}`, map[string]string{"v": "a[*]"}, func(e *Extractor) {
+		e.NormalizeNumbers = true
+		e.Verbatim = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"v": {"1.50"}})
+
+	e, err = extract(`{"a":[1.2.3,4.0]}`, map[string]string{"v": "a[*]"}, func(e *Extractor) {
+		e.NormalizeNumbers = true
+		e.CollectErrors = true
+	})
+	if err != nil || len(e.Errors()) != 1 {
+		t.Fatalf("collected %v, %v", e.Errors(), err)
+	}
+	checkResults(t, e.Results, map[string][]string{"v": {"4"}})
+}
+
+func TestGroupKey(t *testing.T) {
+	doc := `{"orders":[
+	{"id":"a1","items":[{"sku":"x","n":1},{"sku":"y"}]},
+	{"id":7,"items":[{"sku":"z"}]},
+	{"id":"a1","items":[{"sku":"w","n":2}]},
+	{"items":[{"sku":"v"}]},
+	{"id":null,"items":[{"sku":"u"}]},
+	{"id":"e\"s","items":[]},
+	{"id":"a1","items":[]}],"z":0}`
+	paths := map[string]string{"v": "orders[*].items[*].sku#group", "n": "orders[*].items[*].n#group", "z": "z"}
+	e, err := extract(doc, paths, func(e *Extractor) {
+		e.GroupKey = "id"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"v": {`["x","y"]`, `["z"]`, `["w"]`, `["v"]`, `["u"]`, "[]", "[]"},
+		"n": {"[1]", "[]", "[2]", "[]", "[]", "[]", "[]"},
+		"z": {"0"},
+	})
+	want := map[string]RecordFields{
+		"a1":  {"v": `["x","y","w"]`, "n": "[1,2]"}, // a duplicate id joins the earlier record
+		"7":   {"v": `["z"]`, "n": "[]"},
+		`e"s`: {"v": "[]", "n": "[]"},
+		// no id, or a null one: only in Results
+	}
+	if !reflect.DeepEqual(e.Grouped, want) {
+		t.Errorf("grouped %v, want %v", e.Grouped, want)
+	}
+}
+
+func TestPartial(t *testing.T) {
+	doc := `{"a":1,"items":[{"id":1},{"id":2},{"id":3,"x":]},"b":2}`
+	paths := map[string]string{"a": "a", "ids": "items[*].id", "all": "items[*].id#array", "g": "items[*].id#group", "b": "b"}
+	e, err := extract(doc, paths, func(e *Extractor) {
+		e.Partial = true
+		e.Defaults = map[string]string{"b": "none"}
+	})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Offset != 46 {
+		t.Fatalf("error %v, want one at offset 46", err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"a":   {"1"},
+		"ids": {"1", "2", "3"},
+		"all": {"[1,2,3]"},
+		"g":   {"[1]", "[2]"}, // the third element was cut short
+	})
+
+	// without Partial the #array paths are left unfinished
+	e, err = extract(doc, paths, nil)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if all, ok := e.Results["all"]; ok {
+		t.Errorf("unfinished #array result %v", all)
+	}
+}
+
+func TestRecordDepths(t *testing.T) {
+	doc := `{"a":1,"b":{"c":2,"d":[3,[4]],"o":{"p":5}},"s":"{\"k\":{\"m\":6}}","z":0}`
+	e, err := extract(doc, map[string]string{
+		"root": "$",
+		"a":    "a",
+		"c":    "b.c",
+		"d":    "b.d[*]",
+		"dd":   "b.d[1][0]",
+		"o":    "b.o",
+		"p":    "..p",
+		"len":  "b.d.@length",
+		"m":    "s~.k.m",
+		"all":  "..*",
+	}, func(e *Extractor) { e.RecordDepths = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{
+		"root": {0},
+		"a":    {1},
+		"c":    {2},
+		"d":    {3, 3},
+		"dd":   {4},
+		"o":    {2},
+		"p":    {3},
+		"len":  {2}, // the depth of the array measured
+		"m":    {3}, // the string at 1, then two objects inside it
+		"all":  {1, 1, 2, 2, 2, 3, 1, 1},
+	}
+	if !reflect.DeepEqual(e.Depths, want) {
+		t.Errorf("depths %v, want %v", e.Depths, want)
+	}
+}
+
+func TestSiblingsAfterContainerTerminal(t *testing.T) {
+	doc := `{"o":{"x":{"y":"}"},"l":[1,"]",{}]},"after":1,
+	"l":[{"obj":{"a":[1,{"b":"\"}"}]} , "n":2},{"obj":[],"n":3}],
+	"arr":[ [1,2] ,[3]],"last":{"k":"v"},"tail":true}`
+	paths := map[string]string{
+		"o": "o", "ox": "o.x", "ol": "o.l", "after": "after", "obj": "l[*].obj", "n": "l[*].n",
+		"arr": "arr[*]", "last": "last", "tail": "tail",
+	}
+	want := map[string][]string{
+		"o":     {`{"x":{"y":"}"},"l":[1,"]",{}]}`},
+		"ox":    {`{"y":"}"}`},
+		"ol":    {`[1,"]",{}]`},
+		"after": {"1"},
+		"obj":   {`{"a":[1,{"b":"\"}"}]}`, "[]"},
+		"n":     {"2", "3"},
+		"arr":   {"[1,2]", "[3]"},
+		"last":  {`{"k":"v"}`},
+		"tail":  {"true"},
+	}
+	setups := map[string]func(*Extractor){
+		"plain":     nil,
+		"zero copy": func(e *Extractor) { e.ZeroCopy = true },
+		"members":   func(e *Extractor) { e.RecordMembers = true },
+		"depths":    func(e *Extractor) { e.RecordDepths = true },
+	}
+	for name, setup := range setups {
+		e, err := extract(doc, paths, setup)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got := e.Results
+		if e.ZeroCopy {
+			got = make(map[string][]string)
+			for path, values := range e.ResultsBytes {
+				for _, value := range values {
+					got[path] = append(got[path], string(value))
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: results %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package jsonextract
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// splitPath splits a query on dots that are outside brackets and quotes.
+func splitPath(query string) []string {
+	return splitOutside(query, '.')
+}
+
+// splitOutside splits query on every sep that is outside brackets and quotes.
+func splitOutside(query string, sep byte) []string {
+	var segments []string
+	depth, inQuote, start := 0, false, 0
+	for i := 0; i < len(query); i++ {
+		if n := delimitedLen(query[i:], '/'); n > 0 && i == start {
+			i += n - 1 // a /pattern/ key may hold anything
+			continue
+		}
+		switch c := query[i]; {
+		case inQuote && c == '\\':
+			i++ // skip escaped character
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == sep && depth == 0:
+			segments = append(segments, query[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, query[start:])
+}
+
+// trimRoot strips a leading `$.` root anchor, and the `$` of `$[0]`, so
+// `$.a.b` compiles like `a.b`. Keys that merely start with '$' are kept.
+func trimRoot(query string) string {
+	if strings.HasPrefix(query, "$..") {
+		return query[1:]
+	}
+	if rest, ok := strings.CutPrefix(query, "$."); ok {
+		return rest
+	}
+	if strings.HasPrefix(query, "$[") {
+		return query[1:]
+	}
+	return query
+}
+
+// splitRecursive splits a query like `a..b.c` into segments, reporting for
+// each whether '..' precedes it. A query may also start with '..'. It is not
+// ok if the query has no segments or an empty one, as in `a.` or `a...b`.
+func splitRecursive(query string) (segments []string, recursive []bool, ok bool) {
+	parts := splitPath(query)
+	if parts[0] == "" {
+		if len(parts) < 3 || parts[1] != "" {
+			return nil, nil, false
+		}
+		parts = parts[1:] // a leading '..'
+	}
+	descend := false
+	for i, part := range parts {
+		if part == "" {
+			if descend || i == len(parts)-1 {
+				return nil, nil, false
+			}
+			descend = true
+			continue
+		}
+		segments = append(segments, part)
+		recursive = append(recursive, descend)
+		descend = false
+	}
+	return segments, recursive, true
+}
+
+// splitDepth takes the depth limit of a '..' step, as in ..{3}id, off the
+// segment. The depth is 0 if there is none; it is not ok if the limit is not
+// a whole number above 0 or no key follows it.
+func splitDepth(segment string) (depth int, rest string, ok bool) {
+	if !strings.HasPrefix(segment, "{") {
+		return 0, segment, true
+	}
+	spec, rest, found := strings.Cut(segment[1:], "}")
+	depth, err := strconv.Atoi(spec)
+	if !found || err != nil || depth <= 0 || rest == "" {
+		return 0, segment, false
+	}
+	return depth, rest, true
+}
+
+// splitBracket splits a segment like `items[?name="a]b"]` into its key and
+// the contents of the first bracket, honouring quotes and nested brackets
+// inside it and in a quoted or pattern key like `"a[b"`. Any text after the
+// closing bracket is returned as rest.
+func splitBracket(segment string) (key, index, rest string, ok bool) {
+	open := -1
+	for i, inQuote := delimitedLen(segment, '/'), false; i < len(segment) && open < 0; i++ {
+		switch c := segment[i]; {
+		case inQuote && c == '\\':
+			i++ // skip escaped character
+		case c == '"':
+			inQuote = !inQuote
+		case !inQuote && c == '[':
+			open = i
+		}
+	}
+	if open < 0 {
+		return segment, "", "", false
+	}
+	inQuote, depth := false, 0
+	for i := open + 1; i < len(segment); i++ {
+		switch c := segment[i]; {
+		case inQuote && c == '\\':
+			i++ // skip escaped character
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[':
+			depth++ // e.g. the array of [?tags=["a"]]
+		case c == ']' && depth > 0:
+			depth--
+		case c == ']':
+			return segment[:open], segment[open+1 : i], segment[i+1:], true
+		}
+	}
+	return segment[:open], segment[open+1:], "", true
+}
+
+// splitMatchIndex takes the index following a filter, as in
+// [?status=active][0], off the rest of a segment.
+func splitMatchIndex(index, rest string) (match int, remaining string, ok bool) {
+	if !strings.HasPrefix(index, "?") || !strings.HasPrefix(rest, "[") {
+		return 0, rest, false
+	}
+	_, next, remaining, _ := splitBracket(rest)
+	match, err := strconv.Atoi(next)
+	if err != nil {
+		return 0, rest, false
+	}
+	return match, remaining, true
+}
+
+// splitNeighbor takes the offset following a filter, as in [?id=5]+1 or
+// [?id=5]-1, off the rest of a segment. The offset is 0 when there is none.
+func splitNeighbor(index, rest string) (offset int, remaining string) {
+	if !strings.HasPrefix(index, "?") || !strings.HasPrefix(rest, "+") && !strings.HasPrefix(rest, "-") {
+		return 0, rest
+	}
+	end := strings.IndexByte(rest, '[')
+	if end < 0 {
+		end = len(rest)
+	}
+	offset, err := strconv.Atoi(rest[:end])
+	if err != nil {
+		return 0, rest
+	}
+	return offset, rest[end:]
+}
+
+// metaSelectors are the segments that read a property of the value above
+// them instead of a member; quote one, as in "@type", to match a member.
+var metaSelectors = []string{"@length", "@type", "@keys"}
+
+// unquoteKey unquotes a double-quoted query key, accepting JSON escapes like
+// \/ as well as Go ones. Other keys are returned unchanged.
+func unquoteKey(key string) string {
+	if len(key) < 2 || key[0] != '"' || key[len(key)-1] != '"' {
+		return key
+	}
+	if unquoted, err := strconv.Unquote(key); err == nil {
+		return unquoted
+	}
+	if unquoted, err := Unescape([]byte(key[1 : len(key)-1])); err == nil {
+		return string(unquoted)
+	}
+	return key
+}
+
+// parseAlternatives parses a key of the form `(a|b|c)`.
+func parseAlternatives(key string) ([][]byte, bool) {
+	if !strings.HasPrefix(key, "(") || !strings.HasSuffix(key, ")") {
+		return nil, false
+	}
+	var alternatives [][]byte
+	for _, alternative := range strings.Split(key[1:len(key)-1], "|") {
+		alternatives = append(alternatives, []byte(alternative))
+	}
+	return alternatives, true
+}
+
+// parseFilter parses the `key=value` part of a filter, where = may also be
+// one of != < <= > >=. An empty key or @ compares the element itself, as in
+// `tags[?=urgent]`, `ids[?>100]` or `tags[?@=urgent]`. A double-quoted value
+// is unquoted, so it may contain spaces, '=', ']' and escaped quotes. A JSON
+// object or array value, as in `items[?meta={"region":"us"}]`, matches by
+// containment and only supports = and !=. The key @type tests the element's
+// JSON type, as in `mixed[?@type=number]`; quote it to compare a member named
+// @type. Spaces around the key and value are ignored, as in
+// `items[? price = 100 ]`, while a quoted value keeps the spaces inside it.
+func parseFilter(spec string) *PathFilter {
+	from := len(spec) - len(strings.TrimLeft(spec, " "))
+	from += delimitedLen(spec[from:], '"') // a quoted key may hold operators
+	i := strings.IndexAny(spec[from:], "=!<>")
+	if i < 0 {
+		return nil
+	}
+	i += from
+	op := spec[i : i+1]
+	if op != "=" && i+1 < len(spec) && spec[i+1] == '=' {
+		op += "="
+	}
+	if op == "!" {
+		return nil
+	}
+	key := strings.TrimSpace(spec[:i])
+	value := strings.TrimSpace(spec[i+len(op):])
+	if key == "@type" {
+		if op != "=" && op != "!=" || !slices.Contains(jsonTypes, value) {
+			return nil
+		}
+		return &PathFilter{Op: op, Value: value, Type: true}
+	}
+	if key == "@" {
+		key = "" // the element itself, as in flags.*[?@=true]
+	} else if unquoted, err := strconv.Unquote(key); err == nil && strings.HasPrefix(key, `"`) {
+		key = unquoted // e.g. a member literally named "@type"
+	}
+	if ref, ok := strings.CutPrefix(value, "@"); ok && ref != "" {
+		return &PathFilter{Key: key, Op: op, Ref: strings.TrimSpace(ref)}
+	}
+	if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		if op != "=" && op != "!=" || Validate([]byte(value)) != nil {
+			return nil
+		}
+		return &PathFilter{Key: key, Op: op, Value: value, Subset: true}
+	}
+	if strings.HasPrefix(value, `"`) {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+	}
+	return &PathFilter{Key: key, Op: op, Value: value}
+}
+
+// delimitedLen returns the length of the string between delim characters,
+// like "a.b" or /^x-/, that s starts with, or 0 if it does not start with a
+// complete one. Backslashes escape the next character.
+func delimitedLen(s string, delim byte) int {
+	if s == "" || s[0] != delim {
+		return 0
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // skip escaped character
+		case delim:
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// parsePattern compiles a /pattern/ key, reporting false for other keys.
+func parsePattern(key string) (*regexp.Regexp, bool, error) {
+	if len(key) < 2 || delimitedLen(key, '/') != len(key) {
+		return nil, false, nil
+	}
+	re, err := regexp.Compile(key[1 : len(key)-1])
+	return re, true, err
+}
+
+// parseSample reads the spec after '*~': a fraction like 0.1 is a rate in
+// (0, 1], a whole number like 100 a sample size.
+func parseSample(spec string) (rate float64, size int, ok bool) {
+	if strings.Contains(spec, ".") {
+		rate, err := strconv.ParseFloat(spec, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return 0, 0, false
+		}
+		return rate, 0, true
+	}
+	size, err := strconv.Atoi(spec)
+	if err != nil || size <= 0 {
+		return 0, 0, false
+	}
+	return 0, size, true
+}
+
+// checkPath reports the first syntax problem in a query that CompilePaths
+// would otherwise skip or silently reinterpret.
+func checkPath(query string) string {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSuffix(query, "#array")
+	query = strings.TrimSuffix(query, "#group")
+	query, first := strings.CutSuffix(query, "#first")
+	query, last := strings.CutSuffix(query, "#last")
+	query, keys := strings.CutSuffix(query, "#keys")
+	query = strings.TrimSuffix(query, "?")
+	if first && last {
+		return "#first and #last together"
+	}
+	for _, suffix := range pathSuffixes {
+		if strings.HasSuffix(query, suffix) {
+			return suffix + " repeated or out of order"
+		}
+	}
+	query = trimRoot(query)
+	if query == "$" || query == "." {
+		if keys {
+			return "#keys needs a '*' or /pattern/ key"
+		}
+		return ""
+	}
+	if query == "" {
+		return "empty path"
+	}
+	segments, recursive, ok := splitRecursive(query)
+	if !ok {
+		return "empty segment"
+	}
+	wild := false
+	for i, segment := range segments {
+		if recursive[i] && strings.HasPrefix(segment, "{") {
+			if _, segment, ok = splitDepth(segment); !ok {
+				return "invalid depth in " + strconv.Quote(segment)
+			}
+		}
+		key, _, _, _ := splitBracket(segment)
+		wild = wild || strings.HasSuffix(strings.TrimSuffix(key, "~"), "*")
+		if _, ok, err := parsePattern(strings.TrimSuffix(key, "~")); ok {
+			wild = true
+			if err != nil {
+				return "invalid key pattern: " + err.Error()
+			}
+		} else if strings.ContainsRune(key, ']') && unquoteKey(key) == key {
+			return "unexpected ']'"
+		} else if problem := checkAlternatives(key); problem != "" {
+			return problem
+		}
+		for rest := segment[len(key):]; rest != ""; {
+			if rest[0] != '[' {
+				return "unexpected " + strconv.Quote(rest) + " after ']'"
+			}
+			_, index, remaining, _ := splitBracket(rest)
+			if len(index)+len(remaining)+2 != len(rest) {
+				return "unterminated bracket"
+			}
+			if problem := checkIndex(index); problem != "" {
+				return problem
+			}
+			_, remaining, _ = splitMatchIndex(index, remaining)
+			_, rest = splitNeighbor(index, remaining)
+		}
+	}
+	if keys && !wild {
+		return "#keys needs a '*' or /pattern/ key"
+	}
+	return ""
+}
+
+// pathSuffixes are the query suffixes in the order addPath cuts them off the
+// end of a query, so the last of them comes first in a query.
+var pathSuffixes = []string{"#array", "#group", "#first", "#last", "#keys"}
+
+// checkAlternatives reports an (a|b) key with an unbalanced parenthesis or an
+// empty alternative.
+func checkAlternatives(key string) string {
+	if unquoteKey(key) != key {
+		return "" // a quoted key may hold anything
+	}
+	if strings.HasPrefix(key, "(") != strings.HasSuffix(key, ")") {
+		return "unbalanced parenthesis in " + strconv.Quote(key)
+	}
+	alternatives, ok := parseAlternatives(key)
+	if ok && slices.ContainsFunc(alternatives, func(a []byte) bool { return len(a) == 0 }) {
+		return "empty alternative in " + strconv.Quote(key)
+	}
+	return ""
+}
+
+func checkIndex(index string) string {
+	position, filter, hasFilter := strings.Cut(index, "?")
+	if hasFilter {
+		if filter == "" {
+			return "empty filter"
+		}
+		f := parseFilter(filter)
+		if f == nil {
+			return "invalid filter " + strconv.Quote(filter)
+		}
+		if f.Value != "" && strings.IndexByte("=!<>", f.Value[0]) >= 0 && !strings.HasSuffix(strings.TrimSpace(filter), `"`) {
+			return "invalid operator in filter " + strconv.Quote(filter) // like x==1
+		}
+		if position == "" {
+			return ""
+		}
+	}
+	if position == "*" {
+		return ""
+	}
+	if spec, ok := strings.CutPrefix(position, "*~"); ok {
+		if _, _, ok := parseSample(spec); !ok {
+			return "invalid sample " + strconv.Quote(spec)
+		}
+		return ""
+	}
+	if position == "" {
+		return "empty index"
+	}
+	if _, err := strconv.Atoi(position); err != nil {
+		return "invalid index " + strconv.Quote(position)
+	}
+	return ""
+}
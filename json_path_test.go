@@ -0,0 +1,622 @@
+package jsonextract
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuotedFilterValues(t *testing.T) {
+	doc := `{"users":[{"name":"O'Brien","id":1},{"name":"New York","id":2},{"name":"a=b","id":3},
+	{"name":"x]y","id":4},{"name":"q\"t","id":5},{"name":"New","id":6}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`users[?name="O'Brien"].id`, []string{"1"}},
+		{`users[?name="New York"].id`, []string{"2"}},
+		{`users[?name="a=b"].id`, []string{"3"}},
+		{`users[?name="x]y"].id`, []string{"4"}},
+		{`users[?name="q\"t"].id`, []string{"5"}},
+		{`users[?name=New].id`, []string{"6"}},
+		{`users[?name="New"].id`, []string{"6"}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"id": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"id": test.want})
+	}
+}
+
+func TestAlternation(t *testing.T) {
+	const query = "user.(email|mail|emailAddress)"
+	tests := []struct {
+		doc  string
+		want map[string][]string
+	}{
+		{`{"user":{"name":"ann","mail":"m"}}`, map[string][]string{"email": {"m"}}},
+		{`{"user":{"name":"ann"}}`, nil},
+		{`{"user":{"mail":"m","email":"e","emailAddress":"a"}}`, map[string][]string{"email": {"m"}}}, // the first in the document
+		{`{"user":{"emailAddress":"a"},"mail":"top"}`, map[string][]string{"email": {"a"}}},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"email": query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.doc, err)
+			continue
+		}
+		checkResults(t, e.Results, test.want)
+	}
+}
+
+func TestChainedIndices(t *testing.T) {
+	doc := `{"matrix":[[1,2,3],[4,5,6]],"cube":[[[1,2,3],[4,5,6]],[[7,8,9]]],"grid":[[1,2],[3],[],[4,5]]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"matrix[1][2]", []string{"6"}},
+		{"matrix[0][0]", []string{"1"}},
+		{"matrix[1]", []string{"[4,5,6]"}},
+		{"cube[0][1][2]", []string{"6"}},
+		{"cube[1][0][0]", []string{"7"}},
+		{"grid[*][0]", []string{"1", "3", "4"}},
+		{"grid[3][*]", []string{"4", "5"}},
+		{"matrix[2][0]", nil},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"v": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestScalarFilters(t *testing.T) {
+	doc := `{"tags":["urgent","low","urgent",1,"x"],"ids":[5,150,100,101.5,"200",-3],
+	"users":[{"age":20},{"age":40}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"tags[?=urgent]", []string{"urgent", "urgent"}},
+		{"tags[?!=urgent]", []string{"low", "1", "x"}},
+		{"ids[?>100]", []string{"150", "101.5"}}, // "200" is a string
+		{"ids[?<=100]", []string{"5", "100", "-3"}},
+		{"ids[?>=101.5]", []string{"150", "101.5"}},
+		{"ids[?=100]", []string{"100"}},
+		{"ids[?<0]", []string{"-3"}},
+		{"users[?age>30].age", []string{"40"}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want})
+	}
+}
+
+func TestFilterIndex(t *testing.T) {
+	doc := `{"orders":[{"status":"done","id":1},{"status":"active","id":2},{"status":"active","id":3},{"status":"done","id":4}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"orders[?status=active][0].id", []string{"2"}},
+		{"orders[?status=active][1].id", []string{"3"}},
+		{"orders[?status=active][-1].id", []string{"3"}},
+		{"orders[?status=done][-2].id", []string{"1"}},
+		{"orders[?status=active][2].id", nil},
+		{"orders[?status=active][-3].id", nil},
+		{"orders[?status=gone][0].id", nil},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"id": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"id": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestIndexWithFilter(t *testing.T) {
+	doc := `{"items":[{"type":"x","v":0},{"type":"y","v":1},{"type":"x","v":2},{"type":"y","v":3}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"items[2].v", []string{"2"}},
+		{"items[?type=x].v", []string{"0", "2"}},
+		{"items[2?type=x].v", []string{"2"}},
+		{"items[1?type=x].v", nil},
+		{"items[9?type=x].v", nil},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"v": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestCompilePathsStrict(t *testing.T) {
+	valid := []string{
+		"a", "a.b.c", "a[0]", "a[*].b", "a[-1]", "a[?x=1]", `a[?name="x==y"]`, "a[?x>=1]", "a[?x!=1]",
+		"a[2?x=1]", "a[?x=1][0]", "m[1][2]", "*.v", "(a|b).c", `"(a".b`, "a..b", "$", "$.a",
+		"a[*]#first", "a[*]#last", "a[*]#array", "*#keys", "a?", "a[*]?#first",
+	}
+	for _, query := range valid {
+		if _, err := CompilePathsStrict(map[string]string{"q": query}); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+
+	invalid := []struct {
+		query, message string
+	}{
+		{"a[0", "unterminated bracket"},
+		{"a[?]", "empty filter"},
+		{"a[]", "empty index"},
+		{"a[x]", `invalid index "x"`},
+		{"a[?x]", `invalid filter "x"`},
+		{"a[?x==1]", `invalid operator in filter "x==1"`},
+		{"a[?x=>1]", `invalid operator in filter "x=>1"`},
+		{"a]", "unexpected ']'"},
+		{"a[0]b", `unexpected "b" after ']'`},
+		{"a.", "empty segment"},
+		{"", "empty path"},
+		{"a#first#last", "#first repeated or out of order"},
+		{"a#last#first", "#first and #last together"},
+		{"a#first#first", "#first repeated or out of order"},
+		{"a#array#keys", "#array repeated or out of order"},
+		{"a#first?", "#first repeated or out of order"},
+		{"(a|b", `unbalanced parenthesis in "(a|b"`},
+		{"x.a|b)", `unbalanced parenthesis in "a|b)"`},
+		{"(a||b)", `empty alternative in "(a||b)"`},
+		{"a#keys", "#keys needs a '*' or /pattern/ key"},
+	}
+	for _, test := range invalid {
+		_, err := CompilePathsStrict(map[string]string{"q": test.query})
+		var perr *PathError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: got %v, want a *PathError", test.query, err)
+			continue
+		}
+		if perr.Name != "q" || perr.Query != test.query || perr.Message != test.message {
+			t.Errorf("%s: got %q, want %q", test.query, perr.Message, test.message)
+		}
+	}
+}
+
+func TestRootAnchor(t *testing.T) {
+	doc := `{"a":{"b":[1,2]},"$x":3}`
+	tests := []struct {
+		anchored, plain string
+	}{
+		{"$.a", "a"},
+		{"$.a.b[0]", "a.b[0]"},
+		{"$.a.b[*]", "a.b[*]"},
+		{"$..b", "..b"},
+		{"$x", "$x"}, // a key starting with '$'
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.anchored, "w": test.plain}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.anchored, err)
+			continue
+		}
+		if len(e.Results["v"]) == 0 || !reflect.DeepEqual(e.Results["v"], e.Results["w"]) {
+			t.Errorf("%s gave %v, %s gave %v", test.anchored, e.Results["v"], test.plain, e.Results["w"])
+		}
+	}
+
+	e, err := extract(`[{"a":1},{"a":2}]`, map[string]string{"root": "$", "first": "$[0].a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"root": {`[{"a":1},{"a":2}]`}, "first": {"1"}})
+}
+
+func TestRecursiveOccurrence(t *testing.T) {
+	doc := `{"id":0,"a":{"id":1,"b":[{"id":2},{"c":{"id":3}}]},"d":{"id":4}}`
+	e, err := extract(doc, map[string]string{
+		"all":    "..id",
+		"first":  "..id[0]",
+		"third":  "..id[2]",
+		"last":   "..id[4]",
+		"beyond": "..id[5]",
+		"under":  "a..id[1]",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"all":   {"0", "1", "2", "3", "4"}, // document order
+		"first": {"0"},
+		"third": {"2"},
+		"last":  {"4"},
+		"under": {"2"},
+	})
+}
+
+func TestFieldReferenceFilters(t *testing.T) {
+	doc := `{"ranges":[{"start":1,"end":1,"id":"a"},{"start":1,"end":2,"id":"b"},{"start":3,"id":"c"},
+	{"end":3,"id":"d"},{"start":"x","end":"x","id":"e"},{"start":2,"end":1,"id":"f"},{"start":1.0,"end":1,"id":"g"}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"ranges[?start=@end].id", []string{"a", "e", "g"}},
+		{"ranges[?start!=@end].id", []string{"b", "f"}}, // a missing field matches neither
+		{"ranges[?start<@end].id", []string{"b"}},
+		{"ranges[?start>@end].id", []string{"f"}},
+		{"ranges[?start=@none].id", nil},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"id": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"id": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestObjectFilters(t *testing.T) {
+	doc := `{"items":[{"id":1,"meta":{"region":"us","tier":2}},{"id":2,"meta":{"region":"eu"}},{"id":3,"meta":{"region":"us"}},
+	{"id":4,"meta":"us"},{"id":5},{"id":6,"meta":{"region":"us","nested":{"a":[1,2]}}}],"tags":[{"t":["a","b"]},{"t":["a"]}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`items[?meta={"region":"us"}].id`, []string{"1", "3", "6"}}, // candidates may have more keys
+		{`items[?meta={"region":"us","tier":2.0}].id`, []string{"1"}},
+		{`items[?meta={"region":"us","tier":3}].id`, nil},
+		{`items[?meta={"region":"ca"}].id`, nil},
+		{`items[?meta!={"region":"us"}].id`, []string{"2", "4"}},
+		{`items[?meta={"nested":{"a":[1,2]}}].id`, []string{"6"}},
+		{`items[?meta={"nested":{"a":[2]}}].id`, nil}, // arrays match element by element
+		{`tags[?t=["a"]].t`, []string{`["a"]`}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"v": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestObjectLevelFilter(t *testing.T) {
+	doc := `{"before":{"verified":true,"email":"a"},"after":{"email":"b","verified":true},
+	"unverified":{"email":"c","verified":false},"missing":{"email":"d"},"list":[{"email":"e"}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"before[?verified=true].email", []string{"a"}},
+		{"after[?verified=true].email", []string{"b"}}, // the condition may follow the target
+		{"unverified[?verified=true].email", nil},
+		{"missing[?verified=true].email", nil},
+		{"*[?verified=true].email", []string{"a", "b"}},
+		{"after[?verified=true]", []string{`{"email":"b","verified":true}`}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"v": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	doc := `{"user":{"name":"ann","address":{"city":"Oslo"}},"ids":[1,2]}`
+	tests := []struct {
+		query string
+		want  map[string][]string
+	}{
+		{"name:user.name; city:user.address.city", map[string][]string{"name": {"ann"}, "city": {"Oslo"}}},
+		{"  name : user.name ;city:user.address.city;", map[string][]string{"name": {"ann"}, "city": {"Oslo"}}},
+		{"ids:ids[*]", map[string][]string{"ids": {"1", "2"}}},
+		{"id: ids[0]; id: ids[1]", map[string][]string{"id": {"1", "2"}}},
+		{`n:user[?name="a;b:c"].name`, nil}, // separators inside quotes
+	}
+	for _, test := range tests {
+		root, err := CompileQuery(test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		e := NewExtractor([]byte(doc), root)
+		if err := e.Extract(); err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, e.Results, test.want)
+	}
+
+	for _, query := range []string{"name user.name", ":user.name", "name:", "a:x; b user.name", "x:a[0"} {
+		var perr *PathError
+		if _, err := CompileQuery(query); !errors.As(err, &perr) {
+			t.Errorf("%s: got %v, want a *PathError", query, err)
+		}
+	}
+}
+
+func TestSpacedFilters(t *testing.T) {
+	doc := `{"items":[{"price":100,"name":"New York","id":1},{"price":200,"name":" padded ","id":2}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"items[? price = 100 ].id", []string{"1"}},
+		{"items[?price >= 150].id", []string{"2"}},
+		{`items[? name = "New York" ].id`, []string{"1"}},
+		{`items[?name=" padded "].id`, []string{"2"}}, // spaces inside quotes are kept
+		{`items[?name="padded"].id`, nil},
+		{`items[? "price" = 200].id`, []string{"2"}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"id": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"id": test.want}
+		if test.want == nil {
+			want = nil
+		}
+		checkResults(t, e.Results, want)
+	}
+}
+
+func TestTypeFilters(t *testing.T) {
+	doc := `{"mixed":[1,"a",{"k":1},[2],true,null,-2.5,false,{"@type":"Person","n":"p"}]}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"mixed[?@type=number]", []string{"1", "-2.5"}},
+		{"mixed[?@type=string]", []string{"a"}},
+		{"mixed[?@type=object]", []string{`{"k":1}`, `{"@type":"Person","n":"p"}`}},
+		{"mixed[?@type=array]", []string{"[2]"}},
+		{"mixed[?@type=boolean]", []string{"true", "false"}},
+		{"mixed[?@type=null]", []string{"null"}},
+		{"mixed[?@type!=object]", []string{"1", "a", "[2]", "true", "null", "-2.5", "false"}},
+		{"mixed[?@type=object].k", []string{"1"}},
+		{`mixed[?"@type"=Person].n`, []string{"p"}}, // a member named @type
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want})
+	}
+}
+
+func TestTypeFilterErrors(t *testing.T) {
+	for _, query := range []string{"a[?@type=int]", "a[?@type>number]", "a[?@type=]"} {
+		if _, err := CompilePathsStrict(map[string]string{"v": query}); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
+
+func TestFilterNeighbors(t *testing.T) {
+	doc := `{"items":[{"id":5,"n":"a"},{"id":6,"n":"b"},{"id":5,"n":"c"},{"id":7,"n":"d"}],"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"items[?id=5]+1.n", []string{"b", "d"}},
+		{"items[?id=5]-1.n", []string{"b"}}, // the first match has no previous element
+		{"items[?id=5]+2.n", []string{"c"}},
+		{"items[?id=6]-1.id", []string{"5"}},
+		{"items[?id=5][1]+1.n", []string{"d"}},
+		{"items[?id=6]+1", []string{`{"id":5,"n":"c"}`}},
+		{"items[?id=7]+1.n", nil}, // past the end
+		{"items[?id=5]+10.n", nil},
+		{"items[?id=9]+1.n", nil},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"z": {"0"}}
+		if test.want != nil {
+			want["v"] = test.want
+		}
+		checkResults(t, e.Results, want)
+	}
+
+	for _, query := range []string{"items[?id=5]+x.n", "items[2]+1.n"} {
+		if _, err := CompilePathsStrict(map[string]string{"v": query}); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
+
+func TestKeyPatterns(t *testing.T) {
+	doc := `{"headers":{"x-a":1,"X-b":2,"y-x-c":3,"x-":{"k":4},"id":"abc"},"list":[{"a.1":5},{"a.22":6}],"z":0}`
+	tests := []struct {
+		query string
+		want  []KeyValue
+	}{
+		{"headers./^x-/", []KeyValue{{"x-a", "1"}, {"x-", `{"k":4}`}}},
+		{"headers./x-/", []KeyValue{{"x-a", "1"}, {"y-x-c", "3"}, {"x-", `{"k":4}`}}},
+		{"headers./^(?i)x-/", []KeyValue{{"x-a", "1"}, {"X-b", "2"}, {"x-", `{"k":4}`}}},
+		{"headers./^id$/", []KeyValue{{"id", "abc"}}},
+		{"headers./^x-$/.k", []KeyValue{{"x-", "4"}}},
+		{`list[*]./^a\.\d+$/`, []KeyValue{{"a.1", "5"}, {"a.22", "6"}}},
+	}
+	for _, test := range tests {
+		if _, err := CompilePathsStrict(map[string]string{"v": test.query}); err != nil {
+			t.Errorf("%s: %v", test.query, err)
+		}
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, func(e *Extractor) {
+			e.CapturePairs = true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if got := e.Pairs["v"]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: pairs %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	// an invalid expression is an error when compiling strictly, and
+	// matches nothing otherwise
+	query := "headers./^x-[a/"
+	if _, err := CompilePathsStrict(map[string]string{"v": query}); err == nil || !strings.Contains(err.Error(), "invalid key pattern") {
+		t.Errorf("%s: error %v", query, err)
+	}
+	e, err := extract(doc, map[string]string{"v": query, "z": "z"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"z": {"0"}})
+}
+
+func TestDescentDepth(t *testing.T) {
+	doc := `{"id":1,"a":{"id":2,"b":{"id":3,"c":{"id":4}},"l":[{"id":5,"m":[{"id":6}]}]},"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"..{1}id", []string{"1"}},
+		{"..{2}id", []string{"1", "2"}},
+		{"..{3}id", []string{"1", "2", "3", "5"}}, // array steps do not count
+		{"..id", []string{"1", "2", "3", "4", "5", "6"}},
+		{"a..{1}id", []string{"2"}},
+		{"a..{2}id", []string{"2", "3", "5"}},
+		{"a.b..{1}id", []string{"3"}},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want, "z": {"0"}})
+	}
+
+	// bounded and unbounded descents over the same key
+	e, err := extract(doc, map[string]string{"near": "..{2}id", "all": "..id", "z": "z"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"near": {"1", "2"}, "all": {"1", "2", "3", "4", "5", "6"}, "z": {"0"}})
+
+	for _, query := range []string{"..{0}id", "..{x}id", "..{2}", "..{-1}id"} {
+		if _, err := CompilePathsStrict(map[string]string{"v": query}); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
+
+func TestKeysOfMatchingValues(t *testing.T) {
+	doc := `{"config":{"dark":true,"beta":false,"a\/b":true,"nested":{"x":true},"n":1,"off":false},"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"config.*[?@=true]#keys", []string{"dark", "a/b"}},
+		{"config.*[?@=false]#keys", []string{"beta", "off"}},
+		{"config.*[?@!=true]#keys", []string{"beta", "n", "off"}}, // objects are never compared
+		{"config./^[a-d]/[?@=true]#keys", []string{"dark", "a/b"}},
+		{"config.*#keys", []string{"dark", "beta", "a/b", "nested", "n", "off"}},
+		{"config.*.x#keys", []string{"nested"}}, // the key of the '*' above
+		{"config.*[?x=true]#keys", []string{"nested"}},
+		{"config.*[?@=true]", []string{"true", "true"}},
+	}
+	for _, test := range tests {
+		if _, err := CompilePathsStrict(map[string]string{"v": test.query}); err != nil {
+			t.Errorf("%s: %v", test.query, err)
+		}
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		checkResults(t, e.Results, map[string][]string{"v": test.want, "z": {"0"}})
+	}
+
+	if _, err := CompilePathsStrict(map[string]string{"v": "config.nested#keys"}); err == nil {
+		t.Error("#keys without a '*' or /pattern/ key compiled")
+	}
+}
+
+func TestFiltersPerWildcardElement(t *testing.T) {
+	doc := `{"departments":[
+	{"employees":[{"role":"dev","name":"a"},{"role":"lead","name":"b"}]},
+	{"employees":[{"role":"dev","name":"c"}]},
+	{"employees":[{"role":"lead","name":"d"},{"role":"lead","name":"e"}]},
+	{"x":1}],"z":0}`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"departments[*].employees[?role=lead].name", []string{"b", "d", "e"}},
+		{"departments[*].employees[?role=lead].name#group", []string{`["b"]`, "[]", `["d","e"]`, "[]"}},
+		{"departments[*].employees[?role=lead][0].name", []string{"b", "d"}},
+		{"departments[*].employees[?role=lead][-1].name", []string{"b", "e"}},
+		{"departments[*].employees[-1].name", []string{"b", "c", "e"}},
+		{"departments[*].employees[-2].name", []string{"a", "d"}},
+		{"departments[-2].employees[-1].name", []string{"e"}},
+		{"departments[-1].employees[0].name", nil},
+	}
+	for _, test := range tests {
+		e, err := extract(doc, map[string]string{"v": test.query, "z": "z"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		want := map[string][]string{"z": {"0"}}
+		if test.want != nil {
+			want["v"] = test.want
+		}
+		checkResults(t, e.Results, want)
+	}
+}
@@ -0,0 +1,247 @@
+package jsonextract
+
+import (
+	"bytes"
+	"io"
+)
+
+// Project writes a pruned copy of the document holding only what the
+// compiled paths select, nested as in the source. Objects keep their matched
+// members in document order and arrays their selected elements, so indices
+// close up; a terminal's value is copied whole. '..', '#', meta-selectors,
+// sampling, negative indices, indices into and offsets from filter matches
+// and steps into '~' strings select nothing here. A document where nothing
+// matched projects to an empty object or array, or null.
+//
+// Project reads the document itself, so it is used instead of Extract on a
+// fresh Extractor and leaves Results empty.
+func (e *Extractor) Project(w io.Writer) error {
+	e.Scanner.skipWhitespace()
+	e.rootStart = e.Scanner.Pos()
+	var buf bytes.Buffer
+	ok, err := e.projectValue([]projection{{node: e.Root}}, &buf)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		e.Scanner.pos = e.rootStart
+		switch e.Scanner.peek() {
+		case '{':
+			buf.WriteString("{}")
+		case '[':
+			buf.WriteString("[]")
+		default:
+			buf.WriteString("null")
+		}
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// projection applies a node to a value: to the value itself, or with array
+// set to the elements of the value, as an AsArray node does.
+type projection struct {
+	node  *PathNode
+	array bool
+}
+
+// projectValue writes the part of the next value that stages select and
+// reports whether there was any, always moving past the value.
+func (e *Extractor) projectValue(stages []projection, buf *bytes.Buffer) (bool, error) {
+	s := e.Scanner
+	s.skipWhitespace()
+	start := s.Pos()
+	if c := s.peek(); c != '[' && c != 0 {
+		stages = e.filterObject(stages, start)
+	}
+	for _, p := range stages {
+		if p.node.IsTerminal && !p.array {
+			s.SkipValue()
+			if err := s.Err(); err != nil {
+				return false, err
+			}
+			buf.Write((*s.data)[start:s.pos])
+			return true, nil
+		}
+	}
+
+	tok, _ := s.Token()
+	switch tok {
+	case StartObject:
+		return e.projectObject(stages, buf)
+	case StartArray:
+		return e.projectArray(stages, buf)
+	case NoToken:
+		return false, s.fail(s.Pos(), "unexpected end of input")
+	case EndObject, EndArray:
+		return false, s.failToken(start, tok, "unexpected %s", tok)
+	}
+	return false, nil // a scalar where members or elements were wanted
+}
+
+// filterObject applies array stages with a plain filter to the object or
+// scalar at start, as extractValue does: the value itself is kept or dropped.
+func (e *Extractor) filterObject(stages []projection, start int) []projection {
+	var kept []projection
+	for _, p := range stages {
+		n := p.node
+		if p.array && n.Filter != nil && n.ArrayIndex == -1 && !n.MatchIndexed && n.Neighbor == 0 {
+			matched := e.matchesFilter(n.Filter, start)
+			e.Scanner.pos = start
+			if !matched {
+				continue
+			}
+			p.array = false
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+func (e *Extractor) projectObject(stages []projection, buf *bytes.Buffer) (bool, error) {
+	s := e.Scanner
+	defer s.leave()
+	if err := s.enter(); err != nil {
+		return false, err
+	}
+	mark := buf.Len()
+	buf.WriteByte('{')
+	empty := true
+	for s.More() {
+		raw, err := s.ExpectString()
+		if err != nil {
+			return false, err
+		}
+		if s.peek() == ':' {
+			s.pos++ // skip colon
+		}
+		if err := s.expectValue(); err != nil {
+			return false, err
+		}
+		key := unescaped(raw)
+		var next []projection
+		if e.IgnoreKeys[string(key)] {
+			s.SkipValue()
+			continue
+		}
+		for _, p := range stages {
+			if p.array {
+				continue
+			}
+			for _, child := range p.node.Children {
+				if !child.Recursive && e.matchKey(child, key) {
+					next = append(next, projection{node: child, array: child.AsArray})
+				}
+			}
+		}
+		if len(next) == 0 {
+			s.SkipValue()
+			continue
+		}
+
+		member := buf.Len()
+		if !empty {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('"')
+		buf.Write(raw) // escapes kept as in the source
+		buf.WriteString(`":`)
+		ok, err := e.projectValue(next, buf)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			buf.Truncate(member)
+			continue
+		}
+		empty = false
+	}
+	if err := s.ExpectEndObject(); err != nil {
+		return false, err
+	}
+	if empty {
+		buf.Truncate(mark)
+		return false, nil
+	}
+	buf.WriteByte('}')
+	return true, nil
+}
+
+func (e *Extractor) projectArray(stages []projection, buf *bytes.Buffer) (bool, error) {
+	s := e.Scanner
+	defer s.leave()
+	if err := s.enter(); err != nil {
+		return false, err
+	}
+	// array stages pick elements themselves; value stages reach them through
+	// index steps like m[1][2], or by flattening the array as Extract does
+	var selectors []*PathNode
+	for _, p := range stages {
+		if p.array {
+			selectors = append(selectors, p.node)
+			continue
+		}
+		flatten := false
+		for _, child := range p.node.Children {
+			if child.Nested {
+				selectors = append(selectors, child)
+			} else if !child.Length && child.Meta == "" && !child.Recursive {
+				flatten = true
+			}
+		}
+		if flatten {
+			selectors = append(selectors, p.node)
+		}
+	}
+
+	mark := buf.Len()
+	buf.WriteByte('[')
+	empty := true
+	for idx := 0; s.More(); idx++ {
+		if err := s.nextElement(idx); err != nil {
+			return false, err
+		}
+		elem := s.Pos()
+		var next []projection
+		for _, n := range selectors {
+			if n.ArrayIndex != -1 && n.ArrayIndex != idx || n.FromEnd > 0 || n.MatchIndexed || n.Neighbor != 0 || n.SampleRate > 0 || n.SampleSize > 0 {
+				continue
+			}
+			if n.Filter != nil {
+				matched := e.matchesFilter(n.Filter, elem)
+				s.pos = elem
+				if !matched {
+					continue
+				}
+			}
+			next = append(next, projection{node: n})
+		}
+		if len(next) == 0 {
+			s.SkipValue()
+			continue
+		}
+
+		element := buf.Len()
+		if !empty {
+			buf.WriteByte(',')
+		}
+		ok, err := e.projectValue(next, buf)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			buf.Truncate(element)
+			continue
+		}
+		empty = false
+	}
+	if err := s.ExpectEndArray(); err != nil {
+		return false, err
+	}
+	if empty {
+		buf.Truncate(mark)
+		return false, nil
+	}
+	buf.WriteByte(']')
+	return true, nil
+}
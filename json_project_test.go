@@ -0,0 +1,74 @@
+package jsonextract
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProject(t *testing.T) {
+	doc := `{"user":{"name":"ann","age":30,"address":{"city":"Oslo","zip":"0150"}},
+	"items":[{"id":1,"tags":["a"]},{"id":2,"tags":[]},{"id":3}],
+	"m":[[1,2],[3,4]],"k\"q":1,"n":null}`
+	tests := []struct {
+		paths map[string]string
+		want  string
+	}{
+		{map[string]string{"name": "user.name", "city": "user.address.city"}, `{"user":{"name":"ann","address":{"city":"Oslo"}}}`},
+		{map[string]string{"user": "user.address"}, `{"user":{"address":{"city":"Oslo","zip":"0150"}}}`},
+		{map[string]string{"ids": "items[*].id"}, `{"items":[{"id":1},{"id":2},{"id":3}]}`},
+		{map[string]string{"tags": "items[*].tags"}, `{"items":[{"tags":["a"]},{"tags":[]}]}`},
+		{map[string]string{"second": "items[1].id"}, `{"items":[{"id":2}]}`},
+		{map[string]string{"big": "items[?id>1]"}, `{"items":[{"id":2,"tags":[]},{"id":3}]}`},
+		{map[string]string{"cell": "m[1][0]"}, `{"m":[[3]]}`},
+		{map[string]string{"q": `"k\"q"`, "n": "n"}, `{"k\"q":1,"n":null}`},
+		{map[string]string{"none": "user.phone"}, `{}`},
+		{map[string]string{"none": "user.name.first"}, `{}`}, // a scalar has no members
+	}
+	for _, test := range tests {
+		e := NewExtractor([]byte(doc), CompilePaths(test.paths))
+		var out strings.Builder
+		if err := e.Project(&out); err != nil {
+			t.Errorf("%v: %v", test.paths, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("%v: projected %s, want %s", test.paths, out.String(), test.want)
+		}
+	}
+}
+
+func TestProjectArrayRoot(t *testing.T) {
+	tests := []struct {
+		doc   string
+		paths map[string]string
+		want  string
+	}{
+		{`[{"a":1,"b":2},{"b":3}]`, map[string]string{"a": "[*].a"}, `[{"a":1}]`},
+		{`[1,2,3]`, map[string]string{"x": "[1]"}, `[2]`},
+		{`[1,2,3]`, map[string]string{"x": "[5]"}, `[]`},
+		{`"s"`, map[string]string{"x": "a"}, `null`},
+	}
+	for _, test := range tests {
+		e := NewExtractor([]byte(test.doc), CompilePaths(test.paths))
+		var out strings.Builder
+		if err := e.Project(&out); err != nil {
+			t.Errorf("%s: %v", test.doc, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("%s: projected %s, want %s", test.doc, out.String(), test.want)
+		}
+	}
+}
+
+func TestProjectErrors(t *testing.T) {
+	for _, doc := range []string{`{"a":[1,`, `{"a":{"b":1]}`, `{"a" 1,"b":}`} {
+		e := NewExtractor([]byte(doc), CompilePaths(map[string]string{"b": "a.b"}))
+		var out strings.Builder
+		if err := e.Project(&out); err == nil {
+			t.Errorf("%s: no error, projected %s", doc, out.String())
+		} else if out.Len() != 0 {
+			t.Errorf("%s: wrote %s before failing", doc, out.String())
+		}
+	}
+}
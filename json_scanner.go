@@ -1,64 +1,259 @@
 package jsonextract
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 )
 
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// DefaultMaxDepth bounds the nesting of objects and arrays read recursively,
+// so hostile input fails with an error rather than exhausting the stack.
+const DefaultMaxDepth = 10000
+
 type Scanner struct {
-	data *[]byte
-	pos  int
+	data  *[]byte
+	pos   int
+	err   error
+	stack []byte // open brackets seen by SkipValue
+
+	Lenient       bool // accept trailing commas before '}' and ']', NaN and [-]Infinity as numbers, and unquoted keys as in JSON5
+	AllowComments bool // treat // and /* */ comments as whitespace (JSONC)
+	MaxBytes      int  // fail once scanning reaches this offset, 0 means unlimited
+	MaxDepth      int  // nesting limit, 0 means DefaultMaxDepth and below 0 unlimited
+	depth         int
+	plusSign      bool // accept a leading '+' on numbers, for StrictNumbers to strip
 }
 
 func NewScanner(data *[]byte) *Scanner {
-	return &Scanner{data: data, pos: 0}
+	pos := 0
+	if bytes.HasPrefix(*data, utf8BOM) {
+		pos = len(utf8BOM) // skip byte order mark
+	}
+	return &Scanner{data: data, pos: pos}
+}
+
+// NewScannerRange scans only (*data)[start:end] without copying it; an end
+// below zero means the end of data. Offsets stay relative to the whole of
+// data, so Pos and error offsets point into the larger buffer.
+func NewScannerRange(data *[]byte, start, end int) *Scanner {
+	if end < 0 || end > len(*data) {
+		end = len(*data)
+	}
+	start = min(max(start, 0), end)
+	bounded := (*data)[:end]
+	if bytes.HasPrefix(bounded[start:], utf8BOM) {
+		start += len(utf8BOM) // skip byte order mark
+	}
+	return &Scanner{data: &bounded, pos: start}
+}
+
+func (s *Scanner) Pos() int {
+	return s.pos
+}
+
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// fail records the first error found by the scanner and returns it.
+func (s *Scanner) fail(offset int, format string, args ...any) error {
+	return s.failToken(offset, NoToken, format, args...)
+}
+
+func (s *Scanner) failToken(offset int, got TokenType, format string, args ...any) error {
+	if s.err == nil {
+		s.err = newParseError(*s.data, offset, got, fmt.Sprintf(format, args...))
+	}
+	return s.err
+}
+
+// enter records one more level of nesting, failing past MaxDepth. Every
+// call is paired with a deferred leave.
+func (s *Scanner) enter() error {
+	s.depth++
+	limit := s.MaxDepth
+	if limit == 0 {
+		limit = DefaultMaxDepth
+	}
+	if limit > 0 && s.depth > limit {
+		return s.fail(s.pos, "nesting exceeds depth %d", limit)
+	}
+	return nil
+}
+
+func (s *Scanner) leave() {
+	s.depth--
+}
+
+// limit returns the offset scanning may not pass: the end of the data or
+// MaxBytes, whichever comes first.
+func (s *Scanner) limit() int {
+	if s.MaxBytes > 0 && s.MaxBytes < len(*s.data) {
+		return s.MaxBytes
+	}
+	return len(*s.data)
+}
+
+// exceeded records an error once the scanner has reached MaxBytes.
+func (s *Scanner) exceeded() bool {
+	if s.MaxBytes > 0 && s.pos >= s.MaxBytes && s.pos < len(*s.data) {
+		s.fail(s.MaxBytes, "input exceeds %d bytes", s.MaxBytes)
+		return true
+	}
+	return false
 }
 
 func (s *Scanner) skipWhitespace() {
-	for s.pos < len(*s.data) &&
-		((*s.data)[s.pos] == ' ' ||
-			(*s.data)[s.pos] == '\n' ||
-			(*s.data)[s.pos] == '\t') {
-		s.pos++
+	for limit := s.limit(); s.pos < limit; {
+		switch (*s.data)[s.pos] {
+		case ' ', '\n', '\r', '\t':
+			s.pos++
+		case '/':
+			if !s.AllowComments || !s.skipComment() {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *Scanner) skipComment() bool {
+	rest := (*s.data)[s.pos:]
+	if bytes.HasPrefix(rest, []byte("//")) {
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			s.pos += i + 1
+		} else {
+			s.pos = len(*s.data)
+		}
+		return true
+	}
+	if bytes.HasPrefix(rest, []byte("/*")) {
+		i := bytes.Index(rest[2:], []byte("*/"))
+		if i < 0 {
+			s.fail(s.pos, "unterminated comment")
+			s.pos = len(*s.data)
+			return false
+		}
+		s.pos += i + 4 // skip comment and both delimiters
+		return true
 	}
+	return false
 }
 
+// More reports whether another member or element follows in the current
+// object or array. It is false at a closing bracket, at the end of the input
+// and after an error, so a loop over More never runs past the root value.
 func (s *Scanner) More() bool {
+	if s.err != nil {
+		return false
+	}
 	s.skipWhitespace()
+	if s.Lenient {
+		s.skipTrailingComma()
+	}
 	return s.pos < len(*s.data) && (*s.data)[s.pos] != '}' && (*s.data)[s.pos] != ']'
 }
 
+func (s *Scanner) peek() byte {
+	s.skipWhitespace()
+	if s.pos >= len(*s.data) {
+		return 0
+	}
+	return (*s.data)[s.pos]
+}
+
+// nextElement moves past the comma ahead of element idx of an array and fails
+// unless a value starts there, as in [,] or [1,,2], so a closer or stray comma
+// is never read as an element.
+func (s *Scanner) nextElement(idx int) error {
+	if idx > 0 && s.peek() == ',' {
+		s.pos++ // skip comma
+	}
+	return s.expectValue()
+}
+
+// expectValue fails when the next byte closes a container or separates
+// values instead of starting a value, as after the colon of {"a":}.
+func (s *Scanner) expectValue() error {
+	switch c := s.peek(); c {
+	case ',', ':':
+		return s.fail(s.pos, "unexpected %q", c)
+	case '}':
+		return s.failToken(s.pos, EndObject, "unexpected %s", EndObject)
+	case ']':
+		return s.failToken(s.pos, EndArray, "unexpected %s", EndArray)
+	}
+	return s.err
+}
+
+// skipTrailingComma consumes a comma only when a closing bracket follows it.
+func (s *Scanner) skipTrailingComma() {
+	if s.pos < len(*s.data) && (*s.data)[s.pos] == ',' {
+		start := s.pos
+		s.pos++
+		if c := s.peek(); c == '}' || c == ']' {
+			return
+		}
+		s.pos = start
+	}
+}
+
+// structural marks the bytes SkipValue has to look at.
+var structural = [256]bool{'"': true, '{': true, '}': true, '[': true, ']': true, '/': true}
+
 func (s *Scanner) SkipValue() {
 	t, _ := s.Token()
 
 	if t == StartObject || t == StartArray {
-		n := 0
-		insideString := false
-
+		s.stack = append(s.stack[:0], (*s.data)[s.pos-1])
+		limit := s.limit()
 		for {
+			if s.AllowComments {
+				s.skipWhitespace()
+			}
 			if s.pos >= len(*s.data) {
+				s.fail(s.pos, "unexpected end of input")
 				return
 			}
-			c := (*s.data)[s.pos]
-			s.pos++
+			if s.exceeded() {
+				return
+			}
+
+			for s.pos < limit && !structural[(*s.data)[s.pos]] {
+				s.pos++ // nothing else can change the nesting
+			}
+			if s.pos == limit {
+				continue // reported above
+			}
 
-			if insideString {
-				if c == '\\' {
-					s.pos++ // skip escape character
+			switch c := (*s.data)[s.pos]; c {
+			case '"':
+				// strings are skipped whole so brackets and escaped quotes
+				// inside them are never counted
+				if s.SkipString(); s.err != nil {
+					return
+				}
+				continue
+			case '/':
+				if !s.AllowComments || !s.skipComment() && s.err == nil {
+					s.pos++ // a lone slash, invalid but not structural
 				}
-			} else {
-				switch c {
-				case '{', '[':
-					n++
-				case '}', ']':
-					n--
-					if n <= 0 {
-						return
-					}
+				continue
+			case '{', '[':
+				s.stack = append(s.stack, c)
+			case '}', ']':
+				if open := s.stack[len(s.stack)-1]; open != c-2 { // '{'+2 == '}', '['+2 == ']'
+					s.fail(s.pos, "mismatched %q closing %q", c, open)
+					return
 				}
+				s.stack = s.stack[:len(s.stack)-1]
 			}
-			if c == '"' {
-				insideString = !insideString
+			s.pos++
+			if len(s.stack) == 0 {
+				return
 			}
 		}
 	}
@@ -67,17 +262,60 @@ func (s *Scanner) SkipValue() {
 func (s *Scanner) SkipString() {
 	s.skipWhitespace()
 	if s.pos < len(*s.data) && (*s.data)[s.pos] == '"' {
+		start := s.pos
 		s.pos++ // skip opening quote
-		for s.pos < len(*s.data) && (*s.data)[s.pos] != '"' {
-			if (*s.data)[s.pos] == '\\' {
-				s.pos++ // skip escape character
+		limit := s.limit()
+		for {
+			i := bytes.IndexByte((*s.data)[s.pos:limit], '"')
+			if i < 0 {
+				s.pos = limit
+				if !s.exceeded() {
+					s.fail(start, "unterminated string")
+				}
+				return
+			}
+			s.pos += i
+			// the quote is escaped if an odd run of backslashes precedes it
+			escapes := 0
+			for s.pos-1-escapes > start && (*s.data)[s.pos-1-escapes] == '\\' {
+				escapes++
+			}
+			if escapes%2 == 0 {
+				break
 			}
 			s.pos++
 		}
-		if s.pos < len(*s.data) && (*s.data)[s.pos] == '"' {
-			s.pos++ // skip closing quote
+		s.pos++ // skip closing quote
+	}
+}
+
+func (s *Scanner) skipLiteral(literal string) bool {
+	start, limit := s.pos, s.limit()
+	for s.pos < limit && (*s.data)[s.pos] >= 'a' && (*s.data)[s.pos] <= 'z' {
+		s.pos++
+	}
+	if s.exceeded() {
+		return false
+	}
+	if string((*s.data)[start:s.pos]) != literal {
+		s.fail(start, "invalid literal %q", (*s.data)[start:s.pos])
+		return false
+	}
+	return true
+}
+
+var nonFinite = []string{"NaN", "Infinity", "-Infinity"}
+
+// skipNonFinite consumes one of the literals some encoders write for
+// numbers JSON cannot represent.
+func (s *Scanner) skipNonFinite() bool {
+	for _, literal := range nonFinite {
+		if bytes.HasPrefix((*s.data)[s.pos:], []byte(literal)) {
+			s.pos += len(literal)
+			return true
 		}
 	}
+	return false
 }
 
 type TokenType int
@@ -118,43 +356,122 @@ func (t TokenType) String() string {
 }
 
 func (s *Scanner) ExpectString() ([]byte, error) {
+	start := s.pos
+	if key, ok := s.unquotedKey(); ok {
+		return key, nil
+	}
 	t, val := s.Token()
+	if s.err != nil {
+		return nil, s.err
+	}
 	if t != String {
-		return nil, fmt.Errorf("expected String token, got: %s", t)
+		return nil, s.failToken(start, t, "expected String token, got: %s", t)
 	}
 	return val, nil
 }
 
+// unquotedKey reads an identifier key, as in {name: "x"}, after the comma
+// separating it from the member before. It reads nothing unless Lenient is
+// set and such a key follows; a quoted key is left to Token.
+func (s *Scanner) unquotedKey() ([]byte, bool) {
+	if !s.Lenient || s.err != nil {
+		return nil, false
+	}
+	saved := s.pos
+	if s.peek() == ',' && s.afterMember(s.pos) {
+		s.pos++
+	}
+	s.skipWhitespace()
+	start := s.pos
+	for s.pos < len(*s.data) && identifierByte((*s.data)[s.pos], s.pos > start) {
+		s.pos++
+	}
+	if s.pos == start {
+		s.pos = saved
+		return nil, false
+	}
+	return (*s.data)[start:s.pos], true
+}
+
+// afterMember reports whether the comma at pos follows a member, rather than
+// opening the object as in {,a:1} or doubling a comma as in {a:1,,b:2}.
+func (s *Scanner) afterMember(pos int) bool {
+	for pos--; pos >= 0; pos-- {
+		switch (*s.data)[pos] {
+		case ' ', '\n', '\r', '\t':
+		case '{', ',':
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// identifierByte reports whether c may be part of an unquoted key: ASCII
+// letters, '_', '$' and the bytes of non-ASCII characters, and digits
+// after the first byte.
+func identifierByte(c byte, inner bool) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' || c >= 0x80 ||
+		inner && c >= '0' && c <= '9'
+}
+
 func (s *Scanner) ExpectEndObject() error {
+	start := s.pos
 	t, _ := s.Token()
+	if s.err != nil {
+		return s.err
+	}
 	if t != EndObject {
-		return fmt.Errorf("expected EndObject token, got: %s", t)
+		return s.failToken(start, t, "expected EndObject token, got: %s", t)
 	}
 	return nil
 }
 
 func (s *Scanner) ExpectEndArray() error {
+	start := s.pos
 	t, _ := s.Token()
+	if s.err != nil {
+		return s.err
+	}
 	if t != EndArray {
-		return fmt.Errorf("expected EndArray token, got: %s", t)
+		return s.failToken(start, t, "expected EndArray token, got: %s", t)
 	}
 	return nil
 }
 
 func (s *Scanner) Token() (TokenType, []byte) {
+	if s.err != nil {
+		return NoToken, nil
+	}
 	s.skipWhitespace()
-	if s.pos >= len(*s.data) {
+	if s.pos >= len(*s.data) || s.exceeded() {
 		return NoToken, nil
 	}
 
 	start := s.pos
 	c := (*s.data)[s.pos]
+	// a comma or colon before the token is skipped; a run of them is only
+	// accepted when lenient
+	for skipped := false; c == ',' || c == ':'; skipped = true {
+		if skipped && !s.Lenient {
+			s.fail(s.pos, "unexpected %q", c)
+			return NoToken, nil
+		}
+		s.pos++
+		s.skipWhitespace()
+		if s.pos >= len(*s.data) || s.exceeded() {
+			return NoToken, nil
+		}
+		start, c = s.pos, (*s.data)[s.pos]
+	}
+
 	if c == '"' {
 		s.SkipString()
+		if s.err != nil {
+			return NoToken, nil
+		}
 		return String, (*s.data)[start+1 : s.pos-1]
-	} else if c == ',' || c == ':' {
-		s.pos++ // skip comma or colon
-		return s.Token()
 	} else if c == '{' {
 		s.pos++
 		return StartObject, nil
@@ -167,25 +484,34 @@ func (s *Scanner) Token() (TokenType, []byte) {
 	} else if c == ']' {
 		s.pos++ // skip closing bracket
 		return EndArray, nil
+	} else if s.Lenient && s.skipNonFinite() {
+		return Number, (*s.data)[start:s.pos]
 	} else if c == 'n' {
-		s.pos += 4 // skip "null"
+		if !s.skipLiteral("null") {
+			return NoToken, nil
+		}
 		return Null, nil
 	} else if c == 't' {
-		s.pos += 4 // skip "true"
+		if !s.skipLiteral("true") {
+			return NoToken, nil
+		}
 		return Boolean, (*s.data)[start:s.pos]
 	} else if c == 'f' {
-		s.pos += 5 // skip "false"
+		if !s.skipLiteral("false") {
+			return NoToken, nil
+		}
 		return Boolean, (*s.data)[start:s.pos]
-	} else if (c >= '0' && c <= '9') || c == '-' { // simple number check
-		for s.pos < len(*s.data) && ((*s.data)[s.pos] >= '0' && (*s.data)[s.pos] <= '9' || (*s.data)[s.pos] == '.') {
+	} else if (c >= '0' && c <= '9') || c == '-' || (c == '+' && s.plusSign) { // simple number check
+		limit := s.limit()
+		for s.pos < limit && strings.IndexByte("0123456789.eE+-", (*s.data)[s.pos]) >= 0 {
 			s.pos++
 		}
-		return Number, (*s.data)[start:s.pos]
-	} else {
-		for s.pos < len(*s.data) && !strings.ContainsRune(" \n\t,}]", rune((*s.data)[s.pos])) {
-			s.pos++
+		if s.exceeded() {
+			return NoToken, nil
 		}
+		return Number, (*s.data)[start:s.pos]
 	}
 
+	s.fail(start, "invalid character %q", c)
 	return NoToken, nil
 }
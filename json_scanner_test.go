@@ -0,0 +1,478 @@
+package jsonextract
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestByteOrderMark(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want map[string][]string
+	}{
+		{"object", "\xEF\xBB\xBF{\"a\":1}", map[string][]string{"a": {"1"}}},
+		{"array", "\xEF\xBB\xBF[{\"a\":1},{\"a\":2}]", map[string][]string{"a": {"1"}, "all": {"1", "2"}}},
+		{"whitespace after the mark", "\xEF\xBB\xBF \r\n\t{\"a\":1}", map[string][]string{"a": {"1"}}},
+		{"leading whitespace", "\r\n  \t[{\"a\":1}]", map[string][]string{"a": {"1"}, "all": {"1"}}},
+	}
+	for _, tt := range tests {
+		e, err := extract(tt.doc, map[string]string{"a": "a", "all": "[*].a"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.want["all"] == nil {
+			delete(e.Results, "all")
+		}
+		checkResults(t, e.Results, tt.want)
+	}
+}
+
+func TestByteOrderMarkOnlyAtStart(t *testing.T) {
+	if _, err := extract("{\"a\":\xEF\xBB\xBF1}", map[string]string{"a": "a"}, nil); err == nil {
+		t.Error("a byte order mark inside the document was accepted")
+	}
+}
+
+func lenient(e *Extractor) { e.Scanner.Lenient = true }
+
+func TestLenientTrailingCommas(t *testing.T) {
+	tests := []struct {
+		doc   string
+		paths map[string]string
+		want  map[string][]string
+	}{
+		{`[1,2,3,]`, map[string]string{"all": "[*]"}, map[string][]string{"all": {"1", "2", "3"}}},
+		{`{"a":1,}`, map[string]string{"a": "a"}, map[string][]string{"a": {"1"}}},
+		{`{"a":[1,[2,],{"b":3,},],"c":4,}`, map[string]string{"b": "a[2].b", "c": "c", "n": "a[1][0]"},
+			map[string][]string{"b": {"3"}, "c": {"4"}, "n": {"2"}}},
+		{`{"a":{"x":[1,],},"c":4}`, map[string]string{"a": "a", "c": "c"}, map[string][]string{"a": {`{"x":[1,],}`}, "c": {"4"}}},
+		{"[1 , \n]", map[string]string{"all": "[*]"}, map[string][]string{"all": {"1"}}},
+	}
+	for _, tt := range tests {
+		e, err := extract(tt.doc, tt.paths, lenient)
+		if err != nil {
+			t.Errorf("%s: %v", tt.doc, err)
+			continue
+		}
+		checkResults(t, e.Results, tt.want)
+
+		data := []byte(tt.doc)
+		s := NewScanner(&data)
+		s.Lenient = true
+		if err := s.Validate(); err != nil {
+			t.Errorf("%s: lenient Validate: %v", tt.doc, err)
+		}
+	}
+}
+
+func TestStrictTrailingCommas(t *testing.T) {
+	for _, doc := range []string{`[1,2,]`, `{"a":1,}`, `{"a":[1,],"b":2}`} {
+		if _, err := extract(doc, map[string]string{"a": "a[*]", "b": "b", "all": "[*]"}, nil); err == nil {
+			t.Errorf("%s: trailing comma accepted", doc)
+		}
+		if err := Validate([]byte(doc)); err == nil {
+			t.Errorf("%s: Validate accepted a trailing comma", doc)
+		}
+	}
+}
+
+func TestLenientRejectsOtherCommas(t *testing.T) {
+	for _, doc := range []string{`[,]`, `[1,,]`, `{,}`, `{"a":1,,}`} {
+		data := []byte(doc)
+		s := NewScanner(&data)
+		s.Lenient = true
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: accepted", doc)
+		}
+	}
+}
+
+func comments(e *Extractor) { e.Scanner.AllowComments = true }
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want map[string][]string
+	}{
+		{"line", "{\n// the id\n\"id\": 1, // trailing\n\"n\": \"x\"\n}", map[string][]string{"id": {"1"}, "n": {"x"}}},
+		{"block", "{/* a */\"id\"/* b */:/* c */1/* d */,\"n\":/**/\"x\"}", map[string][]string{"id": {"1"}, "n": {"x"}}},
+		{"multi-line block", "/* header\n * more\n */ {\"id\": 1}", map[string][]string{"id": {"1"}}},
+		{"adjacent to values", "{\"list\":[1/*one*/,2//two\n,3],\"id\":1}", map[string][]string{"id": {"1"}, "list": {"1", "2", "3"}}},
+		{"slashes in a string", `{"n":"http://x/*y*/","id":2}`, map[string][]string{"id": {"2"}, "n": {"http://x/*y*/"}}},
+		{"brackets in a skipped comment", "{\"skip\":{/* } ] */\"a\":[// ]\n1]},\"id\":3}", map[string][]string{"id": {"3"}}},
+		{"captured with comments", "{\"list\":[1 /* ] */]}", map[string][]string{"list": {"1"}}},
+	}
+	for _, tt := range tests {
+		e, err := extract(tt.doc, map[string]string{"id": "id", "n": "n", "list": "list[*]"}, comments)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkResults(t, e.Results, tt.want)
+	}
+}
+
+func TestCommentErrors(t *testing.T) {
+	for _, doc := range []string{"{\"id\":1 /* open", "{\"id\": / 1}"} {
+		if _, err := extract(doc, map[string]string{"id": "id", "z": "z"}, comments); err == nil {
+			t.Errorf("%q: accepted", doc)
+		}
+	}
+	if _, err := extract("{// c\n\"id\":1}", map[string]string{"id": "id"}, nil); err == nil {
+		t.Error("a comment was accepted without AllowComments")
+	}
+}
+
+func TestSkipValueStrings(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want int // offset after the skipped value
+	}{
+		{`{"a":"}"} 1`, 9},
+		{`["]"] 1`, 5},
+		{`{"a":"[{"} 1`, 10},
+		{`{"a":"\""} 1`, 10},
+		{`{"a":"\\"} 1`, 10},
+		{`{"a":"\\\"}"} 1`, 13},
+		{`{"a\"}":{"b":"\\\\"}} 1`, 21},
+		{`"}" 1`, 3},
+	}
+	for _, test := range tests {
+		data := []byte(test.doc)
+		s := NewScanner(&data)
+		s.SkipValue()
+		if err := s.Err(); err != nil {
+			t.Errorf("%s: %v", test.doc, err)
+		} else if s.Pos() != test.want {
+			t.Errorf("%s: skipped to %d, want %d", test.doc, s.Pos(), test.want)
+		}
+	}
+}
+
+func TestSkipValueTrailingEscape(t *testing.T) {
+	for _, doc := range []string{`{"a":"\`, `{"a":"\"}`, `["\\\"]`, `"\`, `"ab\"`} {
+		data := []byte(doc)
+		s := NewScanner(&data)
+		s.SkipValue()
+		if s.Err() == nil {
+			t.Errorf("%s: skipped to %d without an error", doc, s.Pos())
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	limit := func(e *Extractor) { e.Scanner.MaxBytes = 100 }
+	big := strings.Repeat("1,", 1000)
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"wide array", `{"a":[` + big + `1]}`},
+		{"skipped array", `{"b":[` + big + `1],"a":1}`},
+		{"long number", `{"a":` + strings.Repeat("1", 1<<20) + `}`},
+		{"long string", `{"a":"` + strings.Repeat("x", 1<<20) + `"}`},
+		{"long skipped string", `{"b":"` + strings.Repeat("x", 1<<20) + `","a":1}`},
+		{"long literal", `{"a":` + strings.Repeat("t", 1<<20) + `}`},
+		{"whitespace", `{"a":` + strings.Repeat(" ", 1<<20) + `1}`},
+	}
+	for _, test := range tests {
+		e, err := extract(test.doc, map[string]string{"a": "a", "all": "a[*]"}, limit)
+		var perr *ParseError
+		if !errors.As(err, &perr) || !strings.Contains(perr.Message, "exceeds 100 bytes") {
+			t.Errorf("%s: got %v, want the byte limit error", test.name, err)
+		}
+		if len(e.Results["a"]) != 0 {
+			t.Errorf("%s: stored %d bytes", test.name, len(e.Results["a"][0]))
+		}
+		if pos := e.Scanner.Pos(); pos > 100 {
+			t.Errorf("%s: scanned to offset %d", test.name, pos)
+		}
+	}
+
+	// a document within the limit is unaffected
+	e, err := extract(`{"a":1}`, map[string]string{"a": "a"}, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"1"}})
+}
+
+func TestMore(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want bool
+	}{
+		{``, false},
+		{`   `, false},
+		{` }`, false},
+		{"\n]", false},
+		{` 1`, true},
+		{` "a"`, true},
+		{` {`, true},
+	}
+	for _, test := range tests {
+		data := []byte(test.doc)
+		if got := NewScanner(&data).More(); got != test.want {
+			t.Errorf("More() on %q = %v, want %v", test.doc, got, test.want)
+		}
+	}
+}
+
+func TestNewScannerRange(t *testing.T) {
+	data := []byte(`noise {"a":1} more`)
+	s := NewScannerRange(&data, 6, 13)
+	if tok, _ := s.Token(); tok != StartObject {
+		t.Fatalf("first token %v", tok)
+	}
+	s.pos = 6
+	s.SkipValue()
+	if s.Err() != nil || s.Pos() != 13 {
+		t.Errorf("skipped to %d: %v", s.Pos(), s.Err())
+	}
+	if s.More() {
+		t.Error("More() past the end of the range")
+	}
+}
+
+func TestNonFiniteNumbers(t *testing.T) {
+	doc := `{"a":NaN,"b":Infinity,"c":-Infinity,"d":[NaN, -Infinity]}`
+	paths := map[string]string{"a": "a", "b": "b", "c": "c", "d": "d[*]"}
+	e, err := extract(doc, paths, func(e *Extractor) {
+		e.Scanner.Lenient = true
+		e.RecordTypes = true
+		e.NormalizeNumbers = true // keeps them as written
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {"NaN"}, "b": {"Infinity"}, "c": {"-Infinity"}, "d": {"NaN", "-Infinity"}})
+	for name, types := range e.Types {
+		for _, typ := range types {
+			if typ != Number {
+				t.Errorf("%s: type %v", name, typ)
+			}
+		}
+	}
+
+	// "z" keeps the extraction reading past the value
+	for _, doc := range []string{`{"a":NaN}`, `{"a":Infinity}`, `{"a":-Infinity}`} {
+		if _, err := extract(doc, map[string]string{"a": "a", "z": "z"}, nil); err == nil {
+			t.Errorf("%s accepted without Lenient", doc)
+		}
+		if err := Validate([]byte(doc)); err == nil {
+			t.Errorf("Validate accepted %s", doc)
+		}
+	}
+	for _, doc := range []string{`{"a":Nan}`, `{"a":Infinit}`, `{"a":-Inf}`} {
+		if _, err := extract(doc, map[string]string{"a": "a", "z": "z"}, lenient); err == nil {
+			t.Errorf("%s accepted", doc)
+		}
+	}
+}
+
+func TestSkipValueLongInput(t *testing.T) {
+	// runs of plain bytes long enough for the fast paths, with escapes and
+	// brackets at varying distances from them
+	for n := 0; n < 40; n++ {
+		pad := strings.Repeat("x", n)
+		doc := `{"a":["` + pad + `\\",{"b":"` + pad + `\"]}"},` + pad + `1],"c":"` + pad + `\\\\"}`
+		data := []byte(doc + ` 1`)
+		s := NewScanner(&data)
+		s.SkipValue()
+		if s.Err() != nil || s.Pos() != len(doc) {
+			t.Errorf("padding %d: skipped to %d of %d: %v", n, s.Pos(), len(doc), s.Err())
+		}
+	}
+}
+
+// skipDoc returns an object with n members of nested data and one wanted
+// member at the end.
+func skipDoc(n int) []byte {
+	var b strings.Builder
+	b.WriteString(`{`)
+	for i := range n {
+		fmt.Fprintf(&b, `"k%d":{"name":"item \"%d\"","tags":["a","b","c"],"nested":{"x":[1,2,{"y":"z"}],"text":"lorem ipsum dolor sit amet"}},`, i, i)
+	}
+	b.WriteString(`"wanted":1}`)
+	return []byte(b.String())
+}
+
+func BenchmarkSkipValue(b *testing.B) {
+	data := skipDoc(1000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		s := NewScanner(&data)
+		s.SkipValue()
+		if s.Err() != nil {
+			b.Fatal(s.Err())
+		}
+	}
+}
+
+// BenchmarkExtractMostlySkipped extracts one of every hundred keys, so
+// nearly all of the document is skipped.
+func BenchmarkExtractMostlySkipped(b *testing.B) {
+	data := skipDoc(1000)
+	paths := CompilePaths(map[string]string{"a": "k10.name", "b": "k500.nested.x[2].y", "wanted": "wanted"})
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		e := NewExtractor(data, paths)
+		if err := e.Extract(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExtractAfterSkipped(t *testing.T) {
+	e, err := extract(string(skipDoc(200)), map[string]string{"a": "k10.name", "b": "k150.nested.x[2].y", "wanted": "wanted"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{"a": {`item \"10\"`}, "b": {"z"}, "wanted": {"1"}})
+}
+
+func TestHugeStrings(t *testing.T) {
+	body := hugeString(1 << 20)
+	doc := `{"big":"` + body + `","after":"` + body + `","z":1}`
+	e, err := extract(doc, map[string]string{"z": "z"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Results["z"]; len(got) != 1 || got[0] != "1" {
+		t.Errorf("results after the huge strings %v", got)
+	}
+	e, err = extract(doc, map[string]string{"big": "big"}, func(e *Extractor) { e.UnescapeStrings = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Results["big"]; len(got) != 1 || !strings.HasSuffix(got[0], `tail "quoted"\"`) {
+		t.Error("huge string value unescaped wrongly")
+	}
+
+	for _, doc := range []string{`{"big":"` + body + `}`, `{"big":"` + body + `\"}`} {
+		if _, err := extract(doc, map[string]string{"z": "z"}, nil); err == nil {
+			t.Error("unterminated huge string accepted")
+		}
+	}
+}
+
+func BenchmarkSkipHugeString(b *testing.B) {
+	data := []byte(`{"big":"` + hugeString(8<<20) + `","z":1}`)
+	paths := CompilePaths(map[string]string{"z": "z"})
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		e := NewExtractor(data, paths)
+		if err := e.Extract(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSeparatorRuns(t *testing.T) {
+	commas := strings.Repeat(",", 1<<20) // deep enough to overflow a recursive skip
+	tests := []struct {
+		doc    string
+		strict string // tokens read before the error without Lenient
+		loose  string // tokens read with Lenient, which has no error
+	}{
+		{"[1" + commas + "2]", "StartArray Number", "StartArray Number Number EndArray"},
+		{commas + "1", "", "Number"},
+		{",:1", "", "Number"},
+		{`{"a":1,,"b":2}`, "StartObject String Number", "StartObject String Number String Number EndObject"},
+	}
+	for _, test := range tests {
+		for _, lenient := range []bool{false, true} {
+			data := []byte(test.doc)
+			s := NewScanner(&data)
+			s.Lenient = lenient
+			var tokens []string
+			for {
+				tok, _ := s.Token()
+				if tok == NoToken {
+					break
+				}
+				tokens = append(tokens, tok.String())
+			}
+			want := test.strict
+			if lenient {
+				want = test.loose
+			}
+			if got := strings.Join(tokens, " "); got != want {
+				t.Errorf("%.20s lenient %v: tokens %s, want %s", test.doc, lenient, got, want)
+			}
+			if (s.Err() == nil) != lenient {
+				t.Errorf("%.20s lenient %v: error %v", test.doc, lenient, s.Err())
+			}
+		}
+	}
+
+	// a leading comma where a value belongs is malformed
+	for _, doc := range []string{`[,1]`, `{"a":[,1]}`, `{"a":,1}`} {
+		var events eventLog
+		if err := Scan([]byte(doc), &events); err == nil {
+			t.Errorf("%s: Scan accepted", doc)
+		}
+		if _, err := extract(doc, map[string]string{"v": "a[*]", "w": "[*]"}, nil); err == nil {
+			t.Errorf("%s: Extract accepted", doc)
+		}
+	}
+}
+
+func TestUnquotedKeys(t *testing.T) {
+	doc := `{ name: "x", first_name: "y", $id: 1, v2: {inner_key: true}, "quoted": 3, é: 4, _: 5 }`
+	paths := map[string]string{
+		"n": "name", "f": "first_name", "i": "$id", "v": "v2.inner_key", "q": "quoted", "e": "é", "u": "_",
+	}
+	e, err := extract(doc, paths, lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"n": {"x"}, "f": {"y"}, "i": {"1"}, "v": {"true"}, "q": {"3"}, "e": {"4"}, "u": {"5"},
+	})
+	if _, err := extract(doc, paths, nil); err == nil {
+		t.Error("unquoted keys accepted without Lenient")
+	}
+
+	data := []byte(doc)
+	s := NewScanner(&data)
+	s.Lenient = true
+	if err := s.Validate(); err != nil {
+		t.Errorf("lenient Validate: %v", err)
+	}
+	if err := Validate(data); err == nil {
+		t.Error("Validate accepted unquoted keys")
+	}
+	for _, doc := range []string{`{2a: 1}`, `{a-b: 1}`, `{: 1}`} {
+		data := []byte(doc)
+		s := NewScanner(&data)
+		s.Lenient = true
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: lenient Validate accepted", doc)
+		}
+	}
+
+	// a comma only separates members
+	for _, doc := range []string{`{,a: 1}`, `{ , a: 1}`, `{a: 1,,b: 2}`, `{"a": 1,,b: 2}`, `{a: 1, ,b: 2}`} {
+		data := []byte(doc)
+		s := NewScanner(&data)
+		s.Lenient = true
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: lenient Validate accepted", doc)
+		}
+		if _, err := extract(doc, map[string]string{"a": "a", "b": "b", "z": "z"}, lenient); err == nil {
+			t.Errorf("%s: lenient Extract accepted", doc)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package jsonextract
+
+// ExtractStream extracts paths from each of a sequence of concatenated or
+// newline-delimited JSON values. Error offsets are relative to data.
+func ExtractStream(data []byte, paths map[string]string) ([]map[string][]string, error) {
+	root := CompilePaths(paths)
+	var results []map[string][]string
+	pos := 0
+	for {
+		e := NewExtractor(data, root)
+		e.Scanner.pos = max(e.Scanner.pos, pos)
+		e.Scanner.skipWhitespace()
+		if e.Scanner.Pos() >= len(e.RawData) {
+			return results, nil
+		}
+		if err := e.Extract(); err != nil {
+			return results, err
+		}
+		pos = e.Consumed()
+		if err := e.Scanner.Err(); err != nil {
+			return results, err // the rest of a value completed early
+		}
+		results = append(results, e.Results)
+	}
+}
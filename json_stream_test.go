@@ -0,0 +1,73 @@
+package jsonextract
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractStream(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []map[string][]string
+	}{
+		{"two", `{"a":1}{"a":2}`, []map[string][]string{{"a": {"1"}}, {"a": {"2"}}}},
+		{"three", `{"a":1} {"b":0,"a":2}` + "\n" + `{"a":[3]}`, []map[string][]string{{"a": {"1"}}, {"a": {"2"}}, {"a": {"[3]"}}}},
+		{"unmatched", `{"a":1} {"b":2}`, []map[string][]string{{"a": {"1"}}, {}}},
+		{"scalars", `1 "x" {"a":true}`, []map[string][]string{{}, {}, {"a": {"true"}}}},
+		{"empty", " \n\t", nil},
+	}
+	for _, tt := range tests {
+		got, err := ExtractStream([]byte(tt.data), map[string]string{"a": "a"})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractStreamNDJSON(t *testing.T) {
+	data, err := os.ReadFile("testdata/events.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ExtractStream(data, map[string]string{"id": "id", "name": "user.name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids, names []string
+	for _, r := range got {
+		ids = append(ids, r["id"]...)
+		names = append(names, r["name"]...)
+	}
+	if strings.Join(ids, ",") != "1,2,3" || strings.Join(names, ",") != "ann,bob,cy" {
+		t.Errorf("ids %v, names %v", ids, names)
+	}
+}
+
+func TestExtractStreamErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int // records returned before the error
+	}{
+		{"unterminated string after a match", "{\"a\":1,\"b\":\"oops}\n{\"a\":2}", 0},
+		{"mismatched bracket after a match", "{\"a\":1}\n{\"a\":2,\"b\":[}\n{\"a\":3}", 1},
+		{"malformed before a match", "{\"a\":1}\n{\"b\":,\"a\":2}", 1},
+	}
+	for _, tt := range tests {
+		got, err := ExtractStream([]byte(tt.data), map[string]string{"a": "a"})
+		if err == nil {
+			t.Errorf("%s: no error, got %v", tt.name, got)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: %d records before the error, want %d", tt.name, len(got), tt.want)
+		}
+	}
+}
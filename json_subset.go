@@ -0,0 +1,82 @@
+package jsonextract
+
+import "bytes"
+
+// containsJSON reports whether the value at pos in data contains pattern, a
+// JSON value. An object contains pattern when every key of pattern is present
+// with a value containing the pattern's value, so extra keys are allowed at
+// any depth. Arrays need the same length and elements containing the
+// pattern's elements in order. Scalars must have the same type and be equal,
+// numbers by value and strings after unescaping.
+func containsJSON(data []byte, pos int, pattern []byte) bool {
+	p := NewScanner(&pattern)
+	d := &Scanner{data: &data, pos: pos, MaxDepth: -1} // bounded by the pattern's depth
+	return containsValue(d, p) && p.err == nil && d.err == nil
+}
+
+func containsValue(d, p *Scanner) bool {
+	ptok, pval := p.Token()
+	dtok, dval := d.Token()
+	switch ptok {
+	case NoToken, EndObject, EndArray:
+		return false
+	case StartObject:
+		if dtok != StartObject {
+			return false
+		}
+		members := d.pos
+		for p.More() {
+			key, err := p.ExpectString()
+			if err != nil || !findKey(d, members, key) || !containsValue(d, p) {
+				return false
+			}
+		}
+		return p.ExpectEndObject() == nil
+	case StartArray:
+		if dtok != StartArray {
+			return false
+		}
+		for p.More() {
+			if !d.More() || !containsValue(d, p) {
+				return false
+			}
+		}
+		return !d.More() && p.ExpectEndArray() == nil
+	}
+	if ptok != dtok {
+		return false
+	}
+	switch ptok {
+	case Number:
+		return NumbersEqual(pval, dval)
+	case String:
+		return bytes.Equal(unescaped(pval), unescaped(dval))
+	}
+	return bytes.Equal(pval, dval)
+}
+
+// findKey moves d to the value of key in the object whose members start at
+// members, reporting whether the key is present.
+func findKey(d *Scanner, members int, key []byte) bool {
+	d.pos = members
+	key = unescaped(key)
+	for d.More() {
+		k, err := d.ExpectString()
+		if err != nil {
+			return false
+		}
+		if bytes.Equal(unescaped(k), key) {
+			return true
+		}
+		d.SkipValue()
+	}
+	return false
+}
+
+// unescaped decodes a string body, keeping it raw if its escapes are invalid.
+func unescaped(raw []byte) []byte {
+	if v, err := Unescape(raw); err == nil {
+		return v
+	}
+	return raw
+}
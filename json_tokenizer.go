@@ -0,0 +1,116 @@
+package jsonextract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Tokenizer reads the tokens of JSON text from a reader, buffering only the
+// token being read. It works at the level of Scanner.Token: commas and colons
+// are skipped and nesting is not checked, so callers that need well-formed
+// input track brackets themselves or use Validate.
+type Tokenizer struct {
+	Lenient       bool // as for Scanner
+	AllowComments bool // as for Scanner
+
+	r      io.Reader
+	buf    []byte
+	s      Scanner
+	eof    bool
+	err    error
+	base   int // stream offset of buf[0]
+	lines  int // newlines read before buf
+	column int // bytes between the last of those newlines and buf
+}
+
+func NewTokenizer(r io.Reader) *Tokenizer {
+	t := &Tokenizer{r: r}
+	t.s.data = &t.buf
+	return t
+}
+
+// Next returns the next token and, for strings, numbers and booleans, its
+// bytes. Strings, keys included, come without their quotes and with escapes
+// as in the input; see Unescape. The slice points into the tokenizer's buffer
+// and is only valid until the next call to Next, so copy it to keep it.
+//
+// At the end of the input Next returns NoToken and io.EOF. A malformed token
+// is a *ParseError with offsets into the whole stream; it and read errors
+// are returned again by every later call.
+func (t *Tokenizer) Next() (TokenType, []byte, error) {
+	t.s.Lenient, t.s.AllowComments = t.Lenient, t.AllowComments
+	for t.err == nil {
+		if t.base == 0 && t.s.pos == 0 {
+			if len(t.buf) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, t.buf) && !t.eof {
+				t.fill() // maybe the start of a byte order mark
+				continue
+			}
+			if bytes.HasPrefix(t.buf, utf8BOM) {
+				t.s.pos = len(utf8BOM) // skip byte order mark
+			}
+		}
+		start := t.s.pos
+		tok, val := t.s.Token()
+		// a token running to the end of the buffer, like the 12 of a coming
+		// 123, may continue in the next read
+		done := t.s.err == nil && (tok == String || tok == StartObject || tok == EndObject || tok == StartArray || tok == EndArray)
+		if t.eof || done || t.s.pos < len(t.buf) {
+			if t.s.err != nil {
+				t.err = t.streamError(t.s.err)
+				break
+			}
+			if tok == NoToken {
+				t.err = io.EOF
+				break
+			}
+			return tok, val, nil
+		}
+		t.s.pos, t.s.err = start, nil
+		t.fill()
+	}
+	return NoToken, nil, t.err
+}
+
+// fill drops the tokens already returned from the buffer and reads more.
+func (t *Tokenizer) fill() {
+	consumed := t.buf[:t.s.pos]
+	if i := bytes.LastIndexByte(consumed, '\n'); i >= 0 {
+		t.lines += bytes.Count(consumed, []byte{'\n'})
+		t.column = len(consumed) - i - 1
+	} else {
+		t.column += len(consumed)
+	}
+	t.base += t.s.pos
+	t.buf = t.buf[:copy(t.buf, t.buf[t.s.pos:])]
+	t.s.pos = 0
+
+	if len(t.buf) == cap(t.buf) {
+		grown := make([]byte, len(t.buf), max(2*cap(t.buf), 4096))
+		copy(grown, t.buf)
+		t.buf = grown
+	}
+	n, err := t.r.Read(t.buf[len(t.buf):cap(t.buf)])
+	t.buf = t.buf[:len(t.buf)+n]
+	if errors.Is(err, io.EOF) {
+		t.eof = true
+	} else if err != nil {
+		t.err = err
+	}
+}
+
+// streamError moves the position of a parse error in the buffer to the
+// stream.
+func (t *Tokenizer) streamError(err error) error {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return err
+	}
+	moved := *pe
+	moved.Offset += t.base
+	if moved.Line == 1 {
+		moved.Column += t.column
+	}
+	moved.Line += t.lines
+	return &moved
+}
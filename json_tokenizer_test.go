@@ -0,0 +1,86 @@
+package jsonextract
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// tokens reads every token from t as "Type" or "Type:bytes".
+func tokens(t *Tokenizer) ([]string, error) {
+	var got []string
+	for {
+		tok, val, err := t.Next()
+		if err != nil {
+			return got, err
+		}
+		if val != nil {
+			got = append(got, fmt.Sprintf("%s:%s", tok, val))
+		} else {
+			got = append(got, tok.String())
+		}
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	doc := "\uFEFF{\"a\":[1,-2.5e3,{\"b\\\"c\":\"x\\ny\"}],\n \"t\":true,\"f\":false,\"n\":null,\"e\":{},\"l\":[]} "
+	want := []string{
+		"StartObject", "String:a", "StartArray", "Number:1", "Number:-2.5e3",
+		"StartObject", `String:b\"c`, `String:x\ny`, "EndObject", "EndArray",
+		"String:t", "Boolean:true", "String:f", "Boolean:false", "String:n", "Null",
+		"String:e", "StartObject", "EndObject", "String:l", "StartArray", "EndArray",
+		"EndObject",
+	}
+	readers := map[string]func() io.Reader{
+		"whole":    func() io.Reader { return strings.NewReader(doc) },
+		"one byte": func() io.Reader { return iotest.OneByteReader(strings.NewReader(doc)) },
+		"half":     func() io.Reader { return iotest.HalfReader(strings.NewReader(doc)) },
+	}
+	for name, r := range readers {
+		got, err := tokens(NewTokenizer(r()))
+		if err != io.EOF {
+			t.Errorf("%s: ended with %v, want io.EOF", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: tokens\n%v\nwant\n%v", name, got, want)
+		}
+	}
+}
+
+func TestTokenizerErrors(t *testing.T) {
+	doc := "[1,\n  \"ok\",\n  tru]"
+	tz := NewTokenizer(iotest.OneByteReader(strings.NewReader(doc)))
+	got, err := tokens(tz)
+	if want := []string{"StartArray", "Number:1", "String:ok"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens %v, want %v", got, want)
+	}
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Offset != 14 || perr.Line != 3 || perr.Column != 3 {
+		t.Fatalf("error %v, want one at offset 14, line 3, column 3", err)
+	}
+	if _, _, again := tz.Next(); again != err {
+		t.Errorf("next error %v, want %v again", again, err)
+	}
+
+	readErr := errors.New("read failed")
+	tz = NewTokenizer(iotest.ErrReader(readErr))
+	if _, _, err := tz.Next(); err != readErr {
+		t.Errorf("error %v, want %v", err, readErr)
+	}
+}
+
+func TestTokenizerComments(t *testing.T) {
+	tz := NewTokenizer(strings.NewReader("// c\n[1, /* two */ 2]"))
+	tz.AllowComments = true
+	got, err := tokens(tz)
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := []string{"StartArray", "Number:1", "Number:2", "EndArray"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package jsonextract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Unescape decodes the escapes of a JSON string body, as returned by Token
+// for a String. \u escapes outside the BMP are surrogate pairs and decode to
+// a single 4-byte UTF-8 sequence; an unpaired surrogate becomes U+FFFD, as
+// with encoding/json. Raw UTF-8 is copied through unchanged. A body without
+// escapes is returned as is.
+func Unescape(raw []byte) ([]byte, error) {
+	i := bytes.IndexByte(raw, '\\')
+	if i < 0 {
+		return raw, nil
+	}
+	out := make([]byte, i, len(raw))
+	copy(out, raw[:i])
+	for i < len(raw) {
+		if raw[i] != '\\' {
+			// copy the run up to the next escape in one go
+			n := bytes.IndexByte(raw[i:], '\\')
+			if n < 0 {
+				n = len(raw) - i
+			}
+			out = append(out, raw[i:i+n]...)
+			i += n
+			continue
+		}
+		if i+1 >= len(raw) {
+			return nil, errors.New("unterminated escape")
+		}
+		switch e := raw[i+1]; e {
+		case '"', '\\', '/':
+			out = append(out, e)
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'u':
+			r, ok := hex4(raw[i+2:])
+			if !ok {
+				return nil, fmt.Errorf("invalid \\u escape at %d", i)
+			}
+			i += 6
+			if utf16.IsSurrogate(r) {
+				r2, ok := rune(-1), false
+				if i+1 < len(raw) && raw[i] == '\\' && raw[i+1] == 'u' {
+					r2, ok = hex4(raw[i+2:])
+				}
+				if dec := utf16.DecodeRune(r, r2); ok && dec != utf8.RuneError {
+					r = dec
+					i += 6
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			out = utf8.AppendRune(out, r)
+			continue
+		default:
+			return nil, fmt.Errorf("invalid escape %q at %d", e, i)
+		}
+		i += 2
+	}
+	return out, nil
+}
+
+// hex4 parses the four hex digits of a \u escape.
+func hex4(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 10
+		case c >= 'A' && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
@@ -0,0 +1,93 @@
+package jsonextract
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{`plain`, "plain"},
+		{`a\"b\\c\/d`, `a"b\c/d`},
+		{`\b\f\n\r\t`, "\b\f\n\r\t"},
+		{`\u00e9\u4e2d`, "\u00e9\u4e2d"},
+		{`\uD83D\uDE00`, "\U0001F600"},
+		{`x\ud83d\ude00y`, "x\U0001F600y"},
+		{`\uD83D`, "\uFFFD"},
+		{`\uDE00\uD83D`, "\uFFFD\uFFFD"},
+		{`\uD83Dx`, "\uFFFDx"},
+		{"\U0001F600 raw", "\U0001F600 raw"},
+	}
+	for _, test := range tests {
+		got, err := Unescape([]byte(test.raw))
+		if err != nil {
+			t.Errorf("%s: %v", test.raw, err)
+		} else if string(got) != test.want {
+			t.Errorf("Unescape(%s) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+
+	for _, raw := range []string{`\`, `\x`, `\u12`, `\u12G4`} {
+		if _, err := Unescape([]byte(raw)); err == nil {
+			t.Errorf("Unescape(%s) accepted", raw)
+		}
+	}
+}
+
+func TestNonBMPStrings(t *testing.T) {
+	doc := "{\"\U0001F600key\":\"value \U0001F600\",\"k\":\"\U0001F389\U0001F389\"," +
+		`"e":"\uD83D\uDE00!","a":["\uD834\uDD1E",{"` + "\U0001D11E" + `":"x"}]}`
+	e, err := extract(doc, map[string]string{
+		"emoji":  "\U0001F600key",
+		"value":  "k",
+		"pair":   "e",
+		"clef":   "a[0]",
+		"nested": "a[1].\U0001D11E",
+	}, func(e *Extractor) { e.UnescapeStrings = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, e.Results, map[string][]string{
+		"emoji":  {"value \U0001F600"},
+		"value":  {"\U0001F389\U0001F389"},
+		"pair":   {"\U0001F600!"}, // an escaped surrogate pair
+		"clef":   {"\U0001D11E"},
+		"nested": {"x"},
+	})
+}
+
+// hugeString returns the body of a string of about n bytes with an escape
+// every kilobyte and escaped quotes at the very end.
+func hugeString(n int) string {
+	chunk := strings.Repeat("lorem ipsum ", 85) + `\n`
+	return strings.Repeat(chunk, n/len(chunk)) + `tail \"quoted\"\\\"`
+}
+
+func TestUnescapeLongRuns(t *testing.T) {
+	raw := hugeString(1 << 16)
+	got, err := Unescape([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(raw)
+	if string(got) != want {
+		t.Errorf("unescaped %d bytes, want %d", len(got), len(want))
+	}
+	if !strings.HasSuffix(string(got), `tail "quoted"\"`) {
+		t.Errorf("unescaped ending %q", got[len(got)-20:])
+	}
+}
+
+func BenchmarkUnescapeHugeString(b *testing.B) {
+	raw := []byte(hugeString(8 << 20))
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := Unescape(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package jsonextract
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Unmarshal fills the fields of the struct pointed to by v from the paths in
+// their `jsonextract` tags. Slice fields receive every match; scalar fields
+// receive the first match. Strings are stored with their escapes decoded.
+// Fields without a match, or whose match is null, are left unchanged; null
+// slice elements become zero values. Every value is converted even after one
+// fails, which leaves its element zero, and the failures are returned
+// together as *ConversionError values joined per field.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Unmarshal requires a non-nil pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	var fields []string
+	paths := make(map[string]string)
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if query, ok := field.Tag.Lookup("jsonextract"); ok && field.IsExported() {
+			fields = append(fields, field.Name)
+			paths[field.Name] = query
+		}
+	}
+
+	e := NewExtractor(data, CompilePaths(paths))
+	e.RecordTypes = true
+	e.UnescapeStrings = true
+	if err := e.Extract(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range fields {
+		values := e.Results[name]
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(name, rv.FieldByName(name), values, e.Types[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var errUnsupported = errors.New("unsupported field type")
+
+func setField(name string, field reflect.Value, values []string, types []TokenType) error {
+	if field.Kind() != reflect.Slice {
+		if isNull(types, 0) {
+			return nil
+		}
+		if err := setScalar(field, values[0]); errors.Is(err, errUnsupported) {
+			return fmt.Errorf("field %s: %w", name, err)
+		} else if err != nil {
+			return &ConversionError{Field: name, Value: values[0], Err: err}
+		}
+		return nil
+	}
+	var errs []error
+	slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+	for i, value := range values {
+		if isNull(types, i) {
+			continue
+		}
+		if err := setScalar(slice.Index(i), value); errors.Is(err, errUnsupported) {
+			return fmt.Errorf("field %s: %w", name, err) // the same for every element
+		} else if err != nil {
+			errs = append(errs, &ConversionError{Field: name, Index: i, Value: value, Err: err})
+		}
+	}
+	field.Set(slice)
+	return errors.Join(errs...)
+}
+
+// isNull reports whether result i was null. Results stored after extraction,
+// like #array ones, have no recorded type.
+func isNull(types []TokenType, i int) bool {
+	return i < len(types) && types[i] == Null
+}
+
+func setScalar(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("%w %s", errUnsupported, field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package jsonextract
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const ordersDoc = `{"user":{"name":"O\"Brien","note":"a\nb","age":41,"admin":true},
+"orders":[{"status":"active","total":9.5},{"status":"done","total":3},{"status":"active","total":12}]}`
+
+func TestUnmarshalScalars(t *testing.T) {
+	var v struct {
+		Name     string  `jsonextract:"user.name"`
+		Note     string  `jsonextract:"user.note"`
+		Age      int     `jsonextract:"user.age"`
+		Admin    bool    `jsonextract:"user.admin"`
+		Total    float64 `jsonextract:"orders[?status=active].total"`
+		Missing  string  `jsonextract:"user.missing"`
+		Untagged int
+	}
+	v.Missing = "kept"
+	if err := Unmarshal([]byte(ordersDoc), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != `O"Brien` || v.Note != "a\nb" {
+		t.Errorf("strings not unescaped: %q %q", v.Name, v.Note)
+	}
+	if v.Age != 41 || !v.Admin || v.Total != 9.5 {
+		t.Errorf("got %+v", v)
+	}
+	if v.Missing != "kept" {
+		t.Errorf("unmatched field overwritten with %q", v.Missing)
+	}
+}
+
+func TestUnmarshalSlices(t *testing.T) {
+	var v struct {
+		Totals   []float64 `jsonextract:"orders[?status=active].total"`
+		Statuses []string  `jsonextract:"orders[*].status"`
+		Values   []int     `jsonextract:"v[*]"`
+	}
+	if err := Unmarshal([]byte(`{"orders":[{"status":"active","total":9.5},{"status":"done","total":3},{"status":"active","total":12}],"v":[1,null,3]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Totals, []float64{9.5, 12}) {
+		t.Errorf("Totals = %v", v.Totals)
+	}
+	if !reflect.DeepEqual(v.Statuses, []string{"active", "done", "active"}) {
+		t.Errorf("Statuses = %v", v.Statuses)
+	}
+	if !reflect.DeepEqual(v.Values, []int{1, 0, 3}) {
+		t.Errorf("Values = %v", v.Values)
+	}
+}
+
+func TestUnmarshalConversionErrors(t *testing.T) {
+	var v struct {
+		Age   int8  `jsonextract:"age"`
+		Flags []int `jsonextract:"flags[*]"`
+		Ok    bool  `jsonextract:"ok"`
+	}
+	err := Unmarshal([]byte(`{"age":300,"flags":[1,"x",3,true],"ok":true}`), &v)
+	var conv *ConversionError
+	if !errors.As(err, &conv) {
+		t.Fatalf("got %v, want a *ConversionError", err)
+	}
+	if !errors.Is(err, strconv.ErrRange) || !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("missing a cause in %v", err)
+	}
+	if !reflect.DeepEqual(v.Flags, []int{1, 0, 3, 0}) || !v.Ok {
+		t.Errorf("other values not stored: %+v", v)
+	}
+
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 2 {
+		t.Errorf("want one error per failing field, got %v", err)
+	}
+}
+
+func TestUnmarshalInvalidTarget(t *testing.T) {
+	var n int
+	var s *struct{}
+	for _, v := range []any{nil, n, &n, s} {
+		if err := Unmarshal([]byte(`{}`), v); err == nil {
+			t.Errorf("Unmarshal(%T) accepted", v)
+		}
+	}
+	var unsupported struct {
+		M map[string]int `jsonextract:"m"`
+	}
+	if err := Unmarshal([]byte(`{"m":1}`), &unsupported); err == nil || !errors.Is(err, errUnsupported) {
+		t.Errorf("map field: %v", err)
+	}
+}
+
+func TestUnmarshalAggregatesSliceErrors(t *testing.T) {
+	var v struct {
+		Prices []float64 `jsonextract:"prices[*]"`
+		Counts []int     `jsonextract:"counts[*]"`
+	}
+	err := Unmarshal([]byte(`{"prices":["1.5","x",2,"",3e1],"counts":[1,2.5,"7"]}`), &v)
+	if err == nil {
+		t.Fatal("no error for invalid values")
+	}
+	var failed []string
+	for _, err := range flatten(err) {
+		var conv *ConversionError
+		if !errors.As(err, &conv) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		failed = append(failed, conv.Field+"["+strconv.Itoa(conv.Index)+"]="+conv.Value)
+	}
+	want := []string{"Prices[1]=x", "Prices[3]=", "Counts[1]=2.5"}
+	if !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed values %q, want %q", failed, want)
+	}
+	if !reflect.DeepEqual(v.Prices, []float64{1.5, 0, 2, 0, 30}) || !reflect.DeepEqual(v.Counts, []int{1, 0, 7}) {
+		t.Errorf("valid values not stored: %+v", v)
+	}
+	if msg := err.Error(); !strings.Contains(msg, `field Prices: value 1 "x"`) {
+		t.Errorf("message %q does not name the value", msg)
+	}
+}
+
+// flatten returns the errors joined in err, recursively.
+func flatten(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, flatten(err)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
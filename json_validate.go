@@ -0,0 +1,193 @@
+package jsonextract
+
+import (
+	"slices"
+	"strings"
+)
+
+func Validate(data []byte) error {
+	return NewScanner(&data).Validate()
+}
+
+// Validate scans the whole document and reports the first syntax error,
+// including any data following the root value.
+func (s *Scanner) Validate() error {
+	if err := s.validateValue(); err != nil {
+		return err
+	}
+	if s.skipWhitespace(); s.pos < len(*s.data) {
+		return s.fail(s.pos, "unexpected data after root value")
+	}
+	return nil
+}
+
+func (s *Scanner) validateValue() error {
+	start := s.pos
+	switch c := s.peek(); c {
+	case 0:
+		return s.fail(s.pos, "unexpected end of input")
+	case ',', ':':
+		return s.fail(s.pos, "unexpected %q", c)
+	}
+
+	t, val := s.Token()
+	if s.err != nil {
+		return s.err
+	}
+	switch t {
+	case StartObject:
+		return s.validateObject()
+	case StartArray:
+		return s.validateArray()
+	case EndObject, EndArray:
+		return s.failToken(start, t, "unexpected %s", t)
+	case String:
+		return s.checkString(s.pos - len(val) - 1)
+	case Number:
+		if !validNumber(val) && !(s.Lenient && slices.Contains(nonFinite, string(val))) {
+			return s.fail(start, "invalid number %q", val)
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) validateObject() error {
+	defer s.leave()
+	if err := s.enter(); err != nil {
+		return err
+	}
+	if s.peek() == '}' {
+		s.pos++
+		return nil
+	}
+	for {
+		if c := s.peek(); c == '"' {
+			key := s.pos + 1
+			if s.SkipString(); s.err != nil {
+				return s.err
+			}
+			if err := s.checkString(key); err != nil {
+				return err
+			}
+		} else if _, ok := s.unquotedKey(); !ok {
+			return s.fail(s.pos, "expected string key, got %q", c)
+		}
+		if c := s.peek(); c != ':' {
+			return s.fail(s.pos, "expected ':' after object key, got %q", c)
+		}
+		s.pos++
+		if err := s.validateValue(); err != nil {
+			return err
+		}
+		switch c := s.peek(); c {
+		case ',':
+			if s.pos++; s.Lenient && s.peek() == '}' {
+				s.pos++
+				return nil
+			}
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return s.fail(s.pos, "expected ',' or '}' in object, got %q", c)
+		}
+	}
+}
+
+func (s *Scanner) validateArray() error {
+	defer s.leave()
+	if err := s.enter(); err != nil {
+		return err
+	}
+	if s.peek() == ']' {
+		s.pos++
+		return nil
+	}
+	for {
+		if err := s.validateValue(); err != nil {
+			return err
+		}
+		switch c := s.peek(); c {
+		case ',':
+			if s.pos++; s.Lenient && s.peek() == ']' {
+				s.pos++
+				return nil
+			}
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return s.fail(s.pos, "expected ',' or ']' in array, got %q", c)
+		}
+	}
+}
+
+// checkString fails on the escapes and control characters JSON does not
+// allow in the string body from start to just before the closing quote at
+// the scanner's position. SkipString, which extraction uses, does not look.
+func (s *Scanner) checkString(start int) error {
+	body := (*s.data)[start : s.pos-1]
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c < 0x20:
+			return s.fail(start+i, "control character %q in string", c)
+		case c == '\\':
+			n := 1 // length of the escape after the backslash
+			if i+1 < len(body) && body[i+1] == 'u' {
+				n = 5
+				for j := i + 2; j < i+6; j++ {
+					if j >= len(body) || !isHex(body[j]) {
+						return s.fail(start+i, "invalid escape %q in string", body[i:min(i+6, len(body))])
+					}
+				}
+			} else if i+1 >= len(body) || !strings.ContainsRune(`"\/bfnrt`, rune(body[i+1])) {
+				return s.fail(start+i, "invalid escape %q in string", body[i:min(i+2, len(body))])
+			}
+			i += n
+		}
+	}
+	return nil
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// validNumber reports whether b follows the JSON number grammar.
+func validNumber(b []byte) bool {
+	i := 0
+	if i < len(b) && b[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(b) && b[i] == '0':
+		i++
+	case i < len(b) && b[i] >= '1' && b[i] <= '9':
+		i = skipDigits(b, i)
+	default:
+		return false
+	}
+	if i < len(b) && b[i] == '.' {
+		if i++; i >= len(b) || b[i] < '0' || b[i] > '9' {
+			return false
+		}
+		i = skipDigits(b, i)
+	}
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		if i++; i < len(b) && (b[i] == '+' || b[i] == '-') {
+			i++
+		}
+		if i >= len(b) || b[i] < '0' || b[i] > '9' {
+			return false
+		}
+		i = skipDigits(b, i)
+	}
+	return i == len(b)
+}
+
+func skipDigits(b []byte, i int) int {
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	return i
+}
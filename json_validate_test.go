@@ -0,0 +1,60 @@
+package jsonextract
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		`{}`, `[]`, `0`, `-1.5e+3`, `"x"`, `true`, `null`,
+		` {"a":[1,{"b":null}],"c":"\"\\\/\b\f\n\r\té"} `,
+		"\xEF\xBB\xBF[1]",
+		`{"é":"😀"}`,
+	}
+	for _, doc := range valid {
+		if err := Validate([]byte(doc)); err != nil {
+			t.Errorf("%s: %v", doc, err)
+		}
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		offset int
+	}{
+		{"empty", ``, 0},
+		{"trailing garbage", `{"a":1} x`, 8},
+		{"second root value", `[1][2]`, 3},
+		{"unterminated string", `{"a":"x}`, 5},
+		{"unterminated object", `{"a":1`, 6},
+		{"mismatched bracket", `{"a":[1}`, 7},
+		{"stray closer", `]`, 0},
+		{"invalid literal", `[tru]`, 1},
+		{"invalid number", `[01]`, 1},
+		{"leading plus", `[+1]`, 1},
+		{"missing colon", `{"a" 1}`, 5},
+		{"missing comma", `[1 2]`, 3},
+		{"trailing comma", `[1,]`, 3},
+		{"unquoted key", `{a:1}`, 1},
+		{"invalid escape", `["a\q"]`, 3},
+		{"short unicode escape", `["\u12"]`, 2},
+		{"invalid escape in key", `{"\x":1}`, 2},
+		{"control character", "[\"a\x01\"]", 3},
+		{"newline in string", "{\"a\":\"x\ny\"}", 7},
+		{"tab in key", "{\"a\tb\":1}", 3},
+	}
+	for _, tt := range tests {
+		err := Validate([]byte(tt.doc))
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: got %v, want a *ParseError", tt.name, err)
+			continue
+		}
+		if perr.Offset != tt.offset {
+			t.Errorf("%s: error at %d, want %d: %v", tt.name, perr.Offset, tt.offset, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package jsonextract
+
+// EventHandler receives the token stream of a document from Walk. Byte slices
+// passed to OnKey and OnValue point into the scanned data.
+type EventHandler interface {
+	OnStartObject()
+	OnKey(key []byte)
+	OnEndObject()
+	OnStartArray()
+	OnEndArray()
+	OnValue(t TokenType, value []byte)
+}
+
+func Scan(data []byte, handler EventHandler) error {
+	return NewScanner(&data).Walk(handler)
+}
+
+// Walk reads one value and reports its structure to handler.
+func (s *Scanner) Walk(handler EventHandler) error {
+	if err := s.walkValue(handler); err != nil {
+		return err
+	}
+	return s.err
+}
+
+func (s *Scanner) walkValue(handler EventHandler) error {
+	start := s.pos
+	t, val := s.Token()
+	if t == StartObject || t == StartArray {
+		defer s.leave()
+		if err := s.enter(); err != nil {
+			return err
+		}
+	}
+	switch t {
+	case StartObject:
+		handler.OnStartObject()
+		for s.More() {
+			key, err := s.ExpectString()
+			if err != nil {
+				return err
+			}
+			handler.OnKey(key)
+			if err := s.walkValue(handler); err != nil {
+				return err
+			}
+		}
+		if err := s.ExpectEndObject(); err != nil {
+			return err
+		}
+		handler.OnEndObject()
+	case StartArray:
+		handler.OnStartArray()
+		for i := 0; s.More(); i++ {
+			if err := s.nextElement(i); err != nil {
+				return err
+			}
+			if err := s.walkValue(handler); err != nil {
+				return err
+			}
+		}
+		if err := s.ExpectEndArray(); err != nil {
+			return err
+		}
+		handler.OnEndArray()
+	case EndObject, EndArray:
+		return s.failToken(start, t, "unexpected %s", t)
+	case NoToken:
+		return s.fail(s.pos, "unexpected end of input")
+	default:
+		handler.OnValue(t, val)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package jsonextract
+
+import (
+	"reflect"
+	"testing"
+)
+
+// eventLog records the events of a walk as strings.
+type eventLog []string
+
+func (l *eventLog) OnStartObject()   { *l = append(*l, "{") }
+func (l *eventLog) OnKey(key []byte) { *l = append(*l, "key "+string(key)) }
+func (l *eventLog) OnEndObject()     { *l = append(*l, "}") }
+func (l *eventLog) OnStartArray()    { *l = append(*l, "[") }
+func (l *eventLog) OnEndArray()      { *l = append(*l, "]") }
+func (l *eventLog) OnValue(t TokenType, value []byte) {
+	*l = append(*l, t.String()+" "+string(value))
+}
+
+func TestScan(t *testing.T) {
+	var got eventLog
+	err := Scan([]byte(`{"a":{"b":[1,"x",true,null]},"c":[],"d":{}}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := eventLog{
+		"{",
+		"key a", "{",
+		"key b", "[", "Number 1", "String x", "Boolean true", "Null ", "]",
+		"}",
+		"key c", "[", "]",
+		"key d", "{", "}",
+		"}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestScanScalar(t *testing.T) {
+	var got eventLog
+	if err := Scan([]byte(` 42 `), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, eventLog{"Number 42"}) {
+		t.Errorf("events %q", got)
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	for _, doc := range []string{``, `{"a":1`, `[1,2`, `]`, `{1:2}`, `{"a":}`} {
+		var got eventLog
+		if err := Scan([]byte(doc), &got); err == nil {
+			t.Errorf("%s: no error after events %q", doc, got)
+		}
+	}
+}